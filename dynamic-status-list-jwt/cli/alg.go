@@ -0,0 +1,129 @@
+package main
+
+import (
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"errors"
+	"fmt"
+	"slices"
+
+	"github.com/lestrrat-go/jwx/v3/jwa"
+	"github.com/lestrrat-go/jwx/v3/jwk"
+	"github.com/lestrrat-go/jwx/v3/jws"
+	"github.com/lestrrat-go/jwx/v3/jwt"
+)
+
+const (
+	defaultAlgorithm = "ES256" // signature algorithm of the issuer keys
+	rsaKeySize       = 3072    // size of generated RSA keys in bits
+)
+
+// Signature algorithms of the issuer keys, and the algorithms verifiers accept by default
+var supportedAlgorithms = []string{"ES256", "ES384", "ES512", "EdDSA", "RS256"}
+
+// ErrAlgorithmNotAllowed is returned for JWTs signed with an algorithm outside the allow-list
+var ErrAlgorithmNotAllowed = errors.New("signature algorithm is not allowed")
+
+// Validate a signature algorithm name
+func validateAlgorithm(name string) error {
+	if !slices.Contains(supportedAlgorithms, name) {
+		return fmt.Errorf("unsupported algorithm %q: use one of %v", name, supportedAlgorithms)
+	}
+	return nil
+}
+
+// Generate a private key for the signature algorithm
+func generateKey(alg string) (interface{}, error) {
+	switch alg {
+	case "ES256":
+		return ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	case "ES384":
+		return ecdsa.GenerateKey(elliptic.P384(), rand.Reader)
+	case "ES512":
+		return ecdsa.GenerateKey(elliptic.P521(), rand.Reader)
+	case "EdDSA":
+		_, sk, err := ed25519.GenerateKey(rand.Reader)
+		return sk, err
+	case "RS256":
+		return rsa.GenerateKey(rand.Reader, rsaKeySize)
+	}
+	return nil, validateAlgorithm(alg)
+}
+
+// Signature algorithm of a key: its alg parameter, or the algorithm of its key type and curve
+// Keys generated before algorithm agility have no alg parameter
+func keyAlgorithm(key jwk.Key) (jwa.SignatureAlgorithm, error) {
+	if alg, ok := key.Algorithm(); ok {
+		sig, ok := jwa.LookupSignatureAlgorithm(alg.String())
+		if !ok {
+			return jwa.EmptySignatureAlgorithm(), fmt.Errorf("%q is not a signature algorithm", alg)
+		}
+		return sig, nil
+	}
+
+	var raw interface{}
+	if err := jwk.Export(key, &raw); err != nil {
+		return jwa.EmptySignatureAlgorithm(), err
+	}
+	switch k := raw.(type) {
+	case *ecdsa.PrivateKey:
+		return curveAlgorithm(k.Curve)
+	case *ecdsa.PublicKey:
+		return curveAlgorithm(k.Curve)
+	case ed25519.PrivateKey, ed25519.PublicKey:
+		return jwa.EdDSA(), nil
+	case *rsa.PrivateKey, *rsa.PublicKey:
+		return jwa.RS256(), nil
+	}
+	return jwa.EmptySignatureAlgorithm(), fmt.Errorf("no signature algorithm for a %s key", key.KeyType())
+}
+
+// ECDSA algorithm of a curve
+func curveAlgorithm(curve elliptic.Curve) (jwa.SignatureAlgorithm, error) {
+	switch curve {
+	case elliptic.P256():
+		return jwa.ES256(), nil
+	case elliptic.P384():
+		return jwa.ES384(), nil
+	case elliptic.P521():
+		return jwa.ES512(), nil
+	}
+	return jwa.EmptySignatureAlgorithm(), fmt.Errorf("unsupported curve %s", curve.Params().Name)
+}
+
+// Verify a signed JWT against a set of keys
+// The alg header must be in the allow-list and match the type and curve of the key,
+// so "none", HMAC with a public key or a key of another type are rejected
+func verifyJWT(raw []byte, set jwk.Set, allowed []string) (jwt.Token, error) {
+	msg, err := jws.Parse(raw)
+	if err != nil || len(msg.Signatures()) != 1 {
+		return nil, errors.New("expected a single signature")
+	}
+	headers := msg.Signatures()[0].ProtectedHeaders()
+	alg, ok := headers.Algorithm()
+	if !ok || alg == jwa.NoSignature() || !slices.Contains(allowed, alg.String()) {
+		return nil, fmt.Errorf("%w: %q", ErrAlgorithmNotAllowed, alg)
+	}
+	kid, _ := headers.KeyID()
+
+	for i := range set.Len() {
+		key, _ := set.Key(i)
+		// A kid selects the key
+		if keyID, ok := key.KeyID(); ok && kid != "" && keyID != kid {
+			continue
+		}
+		// The key must be meant for the algorithm
+		keyAlg, err := keyAlgorithm(key)
+		if err != nil || keyAlg != alg {
+			continue
+		}
+		t, err := jwt.Parse(raw, jwt.WithKey(alg, key), jwt.WithValidate(false))
+		if err == nil {
+			return t, nil
+		}
+	}
+	return nil, fmt.Errorf("no trusted %s key verifies the signature", alg)
+}
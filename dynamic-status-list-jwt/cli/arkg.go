@@ -0,0 +1,246 @@
+package main
+
+import (
+	"crypto/ecdh"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+
+	"filippo.io/bigmod"
+	"github.com/lestrrat-go/jwx/v3/jwk"
+	"golang.org/x/crypto/hkdf"
+)
+
+// Seed modes of new entries
+const (
+	SeedModeSecret = "secret" // seed = H(secret, H(jti)), default
+	SeedModeArkg   = "arkg"   // seed = HKDF(ECDH(issuer key, ARKG-derived holder key), H(jti))
+)
+
+const (
+	arkgInfo     = "dsl/v1 arkg"      // HKDF info of the ARKG blinding factor and MAC key
+	arkgSeedInfo = "dsl/v1 arkg seed" // HKDF info of the seed
+	arkgTagSize  = 16                 // MAC tag length of the key handle
+)
+
+// ArkgSeed is the seed material of an entry in the ARKG seed mode
+// The issuer derives a fresh holder public key from the holder's ARKG master key,
+// only the holder can derive the matching private key from the key handle
+type ArkgSeed struct {
+	Key    string `json:"key"`           // derived holder public key (base64url, uncompressed P-256 point)
+	Handle string `json:"handle"`        // key handle: ephemeral public key || tag (base64url)
+	Kid    string `json:"kid,omitempty"` // issuer key of the ECDH, the first issuer key if empty
+}
+
+// Derive a holder public key from the holder's ARKG master public key (P-256)
+//
+//	ikm      = ECDH(e, S)
+//	ck, mk   = HKDF(ikm, E, "dsl/v1 arkg")
+//	P'       = S + ck * G
+//	handle   = E || HMAC(mk, E)
+func newArkgSeed(master jwk.Key) (*ArkgSeed, error) {
+	var pk ecdsa.PublicKey
+	if err := jwk.Export(master, &pk); err != nil || pk.Curve != elliptic.P256() {
+		return nil, errors.New("the ARKG seed mode requires a P-256 holder key")
+	}
+	masterECDH, err := pk.ECDH()
+	if err != nil {
+		return nil, err
+	}
+
+	ephemeral, err := ecdh.P256().GenerateKey(rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+	ikm, err := ephemeral.ECDH(masterECDH)
+	if err != nil {
+		return nil, err
+	}
+	E := ephemeral.PublicKey().Bytes()
+	ck, mk, err := arkgKeys(ikm, E)
+	if err != nil {
+		return nil, err
+	}
+
+	// P' = S + ck * G, ck * G is the public key of the scalar ck
+	blind, err := ecdh.P256().NewPrivateKey(ck.Bytes(p256N))
+	if err != nil {
+		return nil, err
+	}
+	S, err := p256PointOf(masterECDH)
+	if err != nil {
+		return nil, err
+	}
+	ckG, err := p256PointOf(blind.PublicKey())
+	if err != nil {
+		return nil, err
+	}
+	derived, err := p256Add(S, ckG).publicKey()
+	if err != nil {
+		return nil, err
+	}
+
+	mac := hmac.New(sha256.New, mk)
+	mac.Write(E)
+	handle := append(E, mac.Sum(nil)[:arkgTagSize]...)
+
+	return &ArkgSeed{
+		Key:    base64.RawURLEncoding.EncodeToString(derived.Bytes()),
+		Handle: base64.RawURLEncoding.EncodeToString(handle),
+	}, nil
+}
+
+// Derive the holder private key of a key handle from the holder's ARKG master private key
+//
+//	p' = s + ck mod n
+func deriveArkgPrivateKey(master jwk.Key, handleB64 string) (*ecdh.PrivateKey, error) {
+	var sk ecdsa.PrivateKey
+	if err := jwk.Export(master, &sk); err != nil || sk.Curve != elliptic.P256() {
+		return nil, errors.New("the ARKG seed mode requires a P-256 holder key")
+	}
+	masterECDH, err := sk.ECDH()
+	if err != nil {
+		return nil, err
+	}
+
+	handle, err := base64.RawURLEncoding.DecodeString(handleB64)
+	if err != nil || len(handle) <= arkgTagSize {
+		return nil, errors.New("invalid ARKG key handle")
+	}
+	E, tag := handle[:len(handle)-arkgTagSize], handle[len(handle)-arkgTagSize:]
+	ephemeral, err := ecdh.P256().NewPublicKey(E)
+	if err != nil {
+		return nil, fmt.Errorf("invalid ARKG key handle: %w", err)
+	}
+	ikm, err := masterECDH.ECDH(ephemeral)
+	if err != nil {
+		return nil, err
+	}
+	ck, mk, err := arkgKeys(ikm, E)
+	if err != nil {
+		return nil, err
+	}
+
+	// The tag shows the handle was derived for this master key
+	mac := hmac.New(sha256.New, mk)
+	mac.Write(E)
+	if !hmac.Equal(tag, mac.Sum(nil)[:arkgTagSize]) {
+		return nil, errors.New("the ARKG key handle was derived for another holder key")
+	}
+
+	d, err := bigmod.NewNat().SetBytes(masterECDH.Bytes(), p256N)
+	if err != nil {
+		return nil, err
+	}
+	return ecdh.P256().NewPrivateKey(d.Add(ck, p256N).Bytes(p256N))
+}
+
+// Blinding factor ck (a scalar) and MAC key mk of an ARKG derivation
+func arkgKeys(ikm []byte, ephemeral []byte) (*bigmod.Nat, []byte, error) {
+	// 48 bytes reduced mod n, the bias is negligible
+	okm := make([]byte, 48+32)
+	if _, err := io.ReadFull(hkdf.New(sha256.New, ikm, ephemeral, []byte(arkgInfo)), okm); err != nil {
+		return nil, nil, err
+	}
+	ck, err := p256ScalarReduce(okm[:48])
+	if err != nil {
+		return nil, nil, err
+	}
+	return ck, okm[48:], nil
+}
+
+// Seed of an ARKG entry from the shared secret, computed by the issuer with its key and the
+// derived holder public key, or by the holder with the derived private key and the issuer public key
+//
+//	seed = HKDF(ECDH(sk, pk), H(jti), "dsl/v1 arkg seed")
+func arkgSeed(shared []byte, jtiDigest [32]byte) ([32]byte, error) {
+	var seed [32]byte
+	_, err := io.ReadFull(hkdf.New(sha256.New, shared, jtiDigest[:], []byte(arkgSeedInfo)), seed[:])
+	return seed, err
+}
+
+// Seed of an ARKG entry on the issuer side
+func (s *Server) arkgEntrySeed(a *ArkgSeed, jtiDigest [32]byte) ([32]byte, error) {
+	key, err := s.arkgIssuerKey(a.Kid)
+	if err != nil {
+		return [32]byte{}, err
+	}
+	raw, err := base64.RawURLEncoding.DecodeString(a.Key)
+	if err != nil {
+		return [32]byte{}, fmt.Errorf("invalid ARKG holder key: %w", err)
+	}
+	pk, err := ecdh.P256().NewPublicKey(raw)
+	if err != nil {
+		return [32]byte{}, fmt.Errorf("invalid ARKG holder key: %w", err)
+	}
+
+	// The ECDH runs in the key backend, the issuer key may be in a token
+	signer, err := key.Signer()
+	if err != nil {
+		return [32]byte{}, err
+	}
+	shared, err := signer.ECDH(pk)
+	if err != nil {
+		return [32]byte{}, err
+	}
+	return arkgSeed(shared, jtiDigest)
+}
+
+// Issuer key of the ECDH with the holder
+// The key stays the same for the lifetime of the entry, even after the issuer keys are rotated
+func (s *Server) arkgIssuerKey(kid string) (*IssuerKey, error) {
+	ring := s.keyRing()
+	key := ring.Legacy()
+	if kid != "" {
+		var ok bool
+		key, ok = ring.Key(kid)
+		if !ok {
+			return nil, fmt.Errorf("issuer key %s of the ARKG seed not found", kid)
+		}
+	}
+	if _, err := key.ecdhPublicKey(); err != nil {
+		return nil, err
+	}
+	return key, nil
+}
+
+// Public ECDH key of a P-256 issuer key, published to the holders of the ARKG entries
+func (k *IssuerKey) ecdhPublicKey() (*ecdh.PublicKey, error) {
+	var pk ecdsa.PublicKey
+	if err := jwk.Export(k.pub, &pk); err != nil || pk.Curve != elliptic.P256() {
+		return nil, errors.New("the ARKG seed mode requires a P-256 issuer key")
+	}
+	return pk.ECDH()
+}
+
+// Seed of an ARKG entry on the holder side
+// issuerKeyB64 is the issuer's public ECDH key from the private metadata
+func holderArkgSeed(master jwk.Key, handle string, issuerKeyB64 string, jti string) ([]byte, error) {
+	sk, err := deriveArkgPrivateKey(master, handle)
+	if err != nil {
+		return nil, err
+	}
+	raw, err := base64.RawURLEncoding.DecodeString(issuerKeyB64)
+	if err != nil {
+		return nil, fmt.Errorf("invalid ARKG issuer key: %w", err)
+	}
+	pk, err := ecdh.P256().NewPublicKey(raw)
+	if err != nil {
+		return nil, fmt.Errorf("invalid ARKG issuer key: %w", err)
+	}
+	shared, err := sk.ECDH(pk)
+	if err != nil {
+		return nil, err
+	}
+	seed, err := arkgSeed(shared, sha256.Sum256([]byte(jti)))
+	if err != nil {
+		return nil, err
+	}
+	return seed[:], nil
+}
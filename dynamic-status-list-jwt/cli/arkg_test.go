@@ -0,0 +1,98 @@
+package main
+
+import (
+	"bytes"
+	"crypto/ecdh"
+	"crypto/rand"
+	"encoding/base64"
+	"testing"
+
+	"filippo.io/bigmod"
+	"github.com/lestrrat-go/jwx/v3/jwk"
+)
+
+func TestArkgDerivedKeysMatch(t *testing.T) {
+	for range 20 {
+		master := newTestHolderKey(t)
+		pub, err := master.PublicKey()
+		if err != nil {
+			t.Fatal(err)
+		}
+		seed, err := newArkgSeed(pub)
+		if err != nil {
+			t.Fatal(err)
+		}
+		sk, err := deriveArkgPrivateKey(master, seed.Handle)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := base64.RawURLEncoding.EncodeToString(sk.PublicKey().Bytes()); got != seed.Key {
+			t.Fatal("the derived private key does not match the derived public key")
+		}
+
+		// The handle is bound to the master key
+		if _, err := deriveArkgPrivateKey(newTestHolderKey(t), seed.Handle); err == nil {
+			t.Fatal("a handle was accepted for another master key")
+		}
+	}
+}
+
+// Keys derived before the constant-time arithmetic, entries of existing lists keep their seeds
+func TestArkgDerivationVector(t *testing.T) {
+	master, err := jwk.ParseKey([]byte(`{"crv":"P-256","d":"rguikzplK5_Mq3FsNUET12Z5uW_jEsRSmkcnPRysZ20","kty":"EC","x":"pmOf2qRjhisK2GnTxlNPuw_RNoGwD14AYLMKiWzOhH0","y":"Nbzs3W-oGCZKu0yuT_OiZPvJK6XltXPXdFOeYQYxSv8"}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	handle := "BOpa6y_EOX6O4ugqx33L7-rYPYevFhFN38yBEsY3BJCXfGJzKPNL28XRiKGx9EPV43cgwXlptSaPUAaZBW0x2U2OeMnTdxKlTrs6dvG9KOwI"
+	wantKey := "BNDFCU2CkWtvYulG_etzBpD3AIs41fLlzNKaU9gG8Q08cyDMLSPoVBDw2VsYqUBkKNvLpDW9ZRYVkki397anCtI"
+	wantD, _ := base64.RawURLEncoding.DecodeString("JSJAmWdTn4XR-6fI51zsOJhWJlhpC0vJG2dR_0XjMeU")
+
+	sk, err := deriveArkgPrivateKey(master, handle)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(sk.Bytes(), wantD) {
+		t.Fatal("the derived private key changed")
+	}
+	if got := base64.RawURLEncoding.EncodeToString(sk.PublicKey().Bytes()); got != wantKey {
+		t.Fatal("the derived public key changed")
+	}
+}
+
+func TestP256AddEdgeCases(t *testing.T) {
+	k, err := ecdh.P256().GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	P, err := p256PointOf(k.PublicKey())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// P + P is the public key of 2k
+	d, err := bigmod.NewNat().SetBytes(k.Bytes(), p256N)
+	if err != nil {
+		t.Fatal(err)
+	}
+	d2, err := bigmod.NewNat().SetBytes(k.Bytes(), p256N)
+	if err != nil {
+		t.Fatal(err)
+	}
+	twoK, err := ecdh.P256().NewPrivateKey(d.Add(d2, p256N).Bytes(p256N))
+	if err != nil {
+		t.Fatal(err)
+	}
+	double, err := p256Add(P, P).publicKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !double.Equal(twoK.PublicKey()) {
+		t.Fatal("P + P is not 2P")
+	}
+
+	// P + (-P) is the point at infinity, which is not a public key
+	negY := feSub(bigmod.NewNat().ExpandFor(p256P), P.y)
+	if _, err := p256Add(P, &p256Point{P.x, negY, P.z}).publicKey(); err == nil {
+		t.Fatal("the point at infinity was accepted as a public key")
+	}
+}
@@ -0,0 +1,147 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math"
+	"math/bits"
+)
+
+const (
+	maxCascadeLevels = 64 // maximum number of levels of a filter cascade
+	maxBloomHashes   = 32 // maximum number of hash functions of a Bloom filter
+)
+
+// BloomFilter is a Bloom filter over status identifiers
+// The bit array is published base64url encoded
+type BloomFilter struct {
+	M    uint64 `json:"m"`    // number of bits
+	K    int    `json:"k"`    // number of hash functions
+	Data string `json:"data"` // base64url encoded bit array
+
+	bits []byte
+}
+
+// NewBloomFilter creates a Bloom filter for n identifiers with the false positive rate p
+// level separates the hash functions of the levels of a filter cascade
+func NewBloomFilter(ids [][]byte, p float64, level int) *BloomFilter {
+	n := float64(max(len(ids), 1))
+	// m = -n ln(p) / ln(2)^2, k = m/n ln(2)
+	m := uint64(math.Ceil(-n * math.Log(p) / (math.Ln2 * math.Ln2)))
+	m = max(m, 8)
+	k := min(max(int(math.Round(float64(m)/n*math.Ln2)), 1), maxBloomHashes)
+
+	f := &BloomFilter{M: m, K: k, bits: make([]byte, (m+7)/8)}
+	for _, id := range ids {
+		for _, i := range f.indexes(id, level) {
+			f.bits[i/8] |= 1 << (i % 8)
+		}
+	}
+	f.Data = base64.RawURLEncoding.EncodeToString(f.bits)
+	return f
+}
+
+// Decode the published bit array
+func (f *BloomFilter) decode() error {
+	bits, err := base64.RawURLEncoding.DecodeString(f.Data)
+	if err != nil {
+		return err
+	}
+	// The bit array must hold m bits, and k bounds the work of a lookup
+	if f.K <= 0 || f.K > maxBloomHashes {
+		return fmt.Errorf("invalid number of Bloom filter hash functions %d: must be between 1 and %d", f.K, maxBloomHashes)
+	}
+	if f.M == 0 || f.M > math.MaxInt || f.M > 8*uint64(len(bits)) || uint64(len(bits)) != (f.M+7)/8 {
+		return errors.New("invalid Bloom filter parameters")
+	}
+	f.bits = bits
+	return nil
+}
+
+// FalsePositiveRate estimates the false positive rate from the share of set bits
+func (f *BloomFilter) FalsePositiveRate() float64 {
+	ones := 0
+	for _, b := range f.bits {
+		ones += bits.OnesCount8(b)
+	}
+	return math.Pow(float64(ones)/float64(f.M), float64(f.K))
+}
+
+// Contains reports whether the identifier may be in the filter
+func (f *BloomFilter) Contains(id []byte, level int) bool {
+	for _, i := range f.indexes(id, level) {
+		if f.bits[i/8]&(1<<(i%8)) == 0 {
+			return false
+		}
+	}
+	return true
+}
+
+// Bit indexes of an identifier (double hashing)
+// h = SHA256(level || id), index_i = h1 + i*h2 mod m
+func (f *BloomFilter) indexes(id []byte, level int) []uint64 {
+	h256 := sha256.New()
+	h256.Write([]byte{byte(level)})
+	h256.Write(id)
+	h := h256.Sum(nil)
+	h1 := binary.BigEndian.Uint64(h[0:8])
+	h2 := binary.BigEndian.Uint64(h[8:16]) | 1
+
+	idx := make([]uint64, f.K)
+	for i := range idx {
+		idx[i] = (h1 + uint64(i)*h2) % f.M
+	}
+	return idx
+}
+
+// NewFilterCascade builds a CRLite-style filter cascade
+// Every identifier of include tests positive and every identifier of exclude tests negative:
+// each level stores the false positives of the previous level, until there are none left.
+// The first level has the cascadeFalsePositiveRate, so other identifiers are almost never included.
+func NewFilterCascade(include [][]byte, exclude [][]byte) ([]*BloomFilter, error) {
+	p := cascadeFalsePositiveRate
+	var levels []*BloomFilter
+	for level := 0; ; level++ {
+		if level == maxCascadeLevels {
+			return nil, errors.New("filter cascade does not converge")
+		}
+		f := NewBloomFilter(include, p, level)
+		levels = append(levels, f)
+
+		var falsePositives [][]byte
+		for _, id := range exclude {
+			if f.Contains(id, level) {
+				falsePositives = append(falsePositives, id)
+			}
+		}
+		if len(falsePositives) == 0 {
+			return levels, nil
+		}
+		include, exclude = falsePositives, include
+		// Deeper levels are small, a false positive rate of 1/2 keeps them compact
+		p = 0.5
+	}
+}
+
+// Look up an identifier in a filter cascade
+// The identifier is included if the first level that rejects it has an odd index,
+// or if no level rejects it and the cascade has an odd number of levels
+func cascadeContains(levels []*BloomFilter, id []byte) bool {
+	for level, f := range levels {
+		if !f.Contains(id, level) {
+			return level%2 == 1
+		}
+	}
+	return len(levels)%2 == 1
+}
+
+// Check the false positive rate of a Bloom filter
+func validateFalsePositiveRate(p float64) error {
+	if p <= 0 || p >= 1 {
+		return fmt.Errorf("invalid false positive rate %v: must be between 0 and 1", p)
+	}
+	return nil
+}
@@ -0,0 +1,404 @@
+package main
+
+import (
+	"cmp"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/lestrrat-go/jwx/v3/jwk"
+	"github.com/lestrrat-go/jwx/v3/jwt"
+)
+
+const (
+	defaultCacheDir     = "dsl-cache"      // disk cache of the fetched status lists
+	defaultFetchTimeout = 10 * time.Second // timeout of a status list request
+	maxCachedWindows    = 3                // windows kept per status list
+	maxStatusListSize   = 256 << 20        // largest accepted status list response
+	defaultJWKSLifetime = 5 * time.Minute  // cache lifetime of a fetched JWKS without Cache-Control
+	minJWKSRefetch      = 30 * time.Second // shortest interval between two fetches of a JWKS
+)
+
+// ErrStatusListUnavailable is returned when the issuer cannot be reached
+var ErrStatusListUnavailable = errors.New("status list distribution point is unavailable")
+
+// StatusClient fetches, verifies and caches remote status lists
+// Lists are cached until nxt, or shorter if the server's Cache-Control says so,
+// and revalidated with their ETag. The next window is fetched with the current one,
+// so when the server cannot be reached the cached windows cover the next period.
+type StatusClient struct {
+	HTTP     *http.Client
+	Opts     VerifyOptions
+	CacheDir string // disk cache for offline use, empty disables it
+
+	mu       sync.Mutex
+	lists    map[string][]*cachedList // status list URL -> cached windows
+	fetching map[string]*listFetch    // status list URL -> request in flight
+}
+
+// A status list request in flight, the other callers of the same URL wait for it
+type listFetch struct {
+	done    chan struct{}
+	fetched *cachedList
+	err     error
+}
+
+// A fetched status list window
+type cachedList struct {
+	DslJwt  string `json:"dsl_jwt"`
+	ETag    string `json:"etag,omitempty"`
+	Expires int64  `json:"expires"` // unix time until the list is fresh
+
+	list *StatusList
+}
+
+// NewStatusClient returns a client that verifies the lists according to opts
+func NewStatusClient(opts VerifyOptions, cacheDir string) *StatusClient {
+	return &StatusClient{
+		HTTP:     &http.Client{Timeout: defaultFetchTimeout},
+		Opts:     opts,
+		CacheDir: cacheDir,
+		lists:    map[string][]*cachedList{},
+		fetching: map[string]*listFetch{},
+	}
+}
+
+// StatusList returns the verified list of the window that contains t
+// stale is set if the server could not be reached and a cached window is returned instead
+func (c *StatusClient) StatusList(listURL string, t int64) (list *StatusList, stale bool, err error) {
+	for {
+		c.mu.Lock()
+		cached := c.cached(listURL, t)
+		if cached != nil && time.Now().Unix() < cached.Expires {
+			c.mu.Unlock()
+			return cached.list, false, nil
+		}
+		if f, ok := c.fetching[listURL]; ok {
+			// Wait for the request in flight, it may fetch the same window
+			c.mu.Unlock()
+			<-f.done
+			switch {
+			case f.err == nil && f.fetched.list.Covers(t):
+				return f.fetched.list, false, nil
+			case errors.Is(f.err, ErrStatusListUnavailable):
+				return c.offline(listURL, t, f.err)
+			}
+			continue
+		}
+		f := &listFetch{done: make(chan struct{})}
+		c.fetching[listURL] = f
+		c.mu.Unlock()
+
+		// The HTTP request is made without holding the cache lock
+		f.fetched, f.err = c.fetch(listURL, "t", t, cached)
+		c.mu.Lock()
+		if f.err == nil {
+			c.store(listURL, f.fetched)
+		}
+		delete(c.fetching, listURL)
+		c.mu.Unlock()
+		close(f.done)
+
+		if f.err != nil {
+			if !errors.Is(f.err, ErrStatusListUnavailable) {
+				return nil, false, f.err
+			}
+			return c.offline(listURL, t, f.err)
+		}
+		c.prefetch(listURL, f.fetched.list)
+		return f.fetched.list, false, nil
+	}
+}
+
+// Offline: fall back to the cached window
+func (c *StatusClient) offline(listURL string, t int64, err error) (*StatusList, bool, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	cached := c.cached(listURL, t)
+	if cached == nil {
+		return nil, false, err
+	}
+	return cached.list, true, nil
+}
+
+// Fetch the window after the current one while the server can be reached
+// The statuses may still change before it starts, so it is stale at once: it is only used offline
+func (c *StatusClient) prefetch(listURL string, current *StatusList) {
+	if current.Nxt <= time.Now().Unix() {
+		return
+	}
+	c.mu.Lock()
+	cached := c.cached(listURL, current.Nxt)
+	c.mu.Unlock()
+	next, err := c.fetch(listURL, "nbf", current.Nxt, cached)
+	if err != nil {
+		log.Println("Warning: failed to fetch the next status list window:", err)
+		return
+	}
+	next.Expires = time.Now().Unix()
+	c.mu.Lock()
+	c.store(listURL, next)
+	c.mu.Unlock()
+}
+
+// Check verifies the holder's proof against the list published at listURL
+func (c *StatusClient) Check(listURL string, h HolderProofPayload) (*VerificationResult, error) {
+	list, stale, err := c.StatusList(listURL, h.Iat)
+	if err != nil {
+		return nil, err
+	}
+	result, err := list.Check(h, c.Opts)
+	if err != nil {
+		return nil, err
+	}
+	result.Stale = stale
+	return result, nil
+}
+
+// Request a window by a time (t) or by its start (nbf)
+// A cached list is revalidated with its ETag
+func (c *StatusClient) fetch(listURL string, param string, value int64, cached *cachedList) (*cachedList, error) {
+	u, err := url.Parse(listURL)
+	if err != nil {
+		return nil, err
+	}
+	query := u.Query()
+	query.Set(param, strconv.FormatInt(value, 10))
+	u.RawQuery = query.Encode()
+
+	req, err := http.NewRequest(http.MethodGet, u.String(), nil)
+	if err != nil {
+		return nil, err
+	}
+	if cached != nil && cached.ETag != "" {
+		req.Header.Set("If-None-Match", cached.ETag)
+	}
+
+	resp, err := c.HTTP.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrStatusListUnavailable, err)
+	}
+	defer resp.Body.Close()
+
+	switch {
+	case resp.StatusCode == http.StatusNotModified && cached != nil:
+		revalidated := *cached
+		revalidated.Expires = expiresAt(resp.Header, cached.list.Nxt)
+		return &revalidated, nil
+	case resp.StatusCode >= http.StatusInternalServerError:
+		return nil, fmt.Errorf("%w: %s", ErrStatusListUnavailable, resp.Status)
+	case resp.StatusCode != http.StatusOK:
+		return nil, fmt.Errorf("failed to fetch the status list: %s", resp.Status)
+	}
+
+	var dsl DslJWT
+	if err := json.NewDecoder(io.LimitReader(resp.Body, maxStatusListSize)).Decode(&dsl); err != nil {
+		return nil, fmt.Errorf("failed to parse the status list: %w", err)
+	}
+	list, err := LoadStatusList([]byte(dsl.DslJwt), c.Opts)
+	if err != nil {
+		return nil, err
+	}
+	return &cachedList{
+		DslJwt:  dsl.DslJwt,
+		ETag:    resp.Header.Get("ETag"),
+		Expires: expiresAt(resp.Header, list.Nxt),
+		list:    list,
+	}, nil
+}
+
+// Freshness of a response: until nxt, unless Cache-Control asks for less
+func expiresAt(header http.Header, nxt int64) int64 {
+	now := time.Now().Unix()
+	expires := nxt
+	for _, directive := range strings.Split(header.Get("Cache-Control"), ",") {
+		directive = strings.TrimSpace(directive)
+		switch {
+		case directive == "no-cache" || directive == "no-store":
+			return now
+		case strings.HasPrefix(directive, "max-age="):
+			maxAge, err := strconv.ParseInt(strings.TrimPrefix(directive, "max-age="), 10, 64)
+			if err == nil {
+				expires = min(expires, now+maxAge)
+			}
+		}
+	}
+	return expires
+}
+
+// Cached window that contains t, c.mu must be held
+func (c *StatusClient) cached(listURL string, t int64) *cachedList {
+	for _, l := range c.load(listURL) {
+		if l.list.Covers(t) {
+			return l
+		}
+	}
+	return nil
+}
+
+// Cached windows of a status list, read from the disk cache on first use
+func (c *StatusClient) load(listURL string) []*cachedList {
+	if lists, ok := c.lists[listURL]; ok {
+		return lists
+	}
+	var lists []*cachedList
+	if c.CacheDir != "" {
+		var stored []*cachedList
+		if err := LoadJSON(&stored, c.cachePath(listURL)); err == nil {
+			for _, l := range stored {
+				// The cached lists are verified again, the trust options may have changed
+				list, err := LoadStatusList([]byte(l.DslJwt), c.Opts)
+				if err != nil {
+					continue
+				}
+				l.list = list
+				lists = append(lists, l)
+			}
+		} else if !errors.Is(err, os.ErrNotExist) {
+			log.Println("Warning: ignoring the status list cache:", err)
+		}
+	}
+	c.lists[listURL] = lists
+	return lists
+}
+
+// Add a window to the cache, replacing the window with the same nbf
+// Only the most recent windows are kept
+func (c *StatusClient) store(listURL string, fetched *cachedList) {
+	lists := []*cachedList{fetched}
+	for _, l := range c.load(listURL) {
+		if l.list.Nbf != fetched.list.Nbf {
+			lists = append(lists, l)
+		}
+	}
+	// Newest first
+	slices.SortFunc(lists, func(a, b *cachedList) int {
+		return cmp.Compare(b.list.Nbf, a.list.Nbf)
+	})
+	if len(lists) > maxCachedWindows {
+		lists = lists[:maxCachedWindows]
+	}
+	c.lists[listURL] = lists
+
+	if c.CacheDir == "" {
+		return
+	}
+	if err := os.MkdirAll(c.CacheDir, 0700); err != nil {
+		log.Println("Warning: failed to create the status list cache:", err)
+		return
+	}
+	if err := SaveJSON(lists, c.cachePath(listURL)); err != nil {
+		log.Println("Warning: failed to cache the status list:", err)
+	}
+}
+
+// Cache file of a status list URL
+func (c *StatusClient) cachePath(listURL string) string {
+	digest := sha256.Sum256([]byte(listURL))
+	return filepath.Join(c.CacheDir, hex.EncodeToString(digest[:8])+".json")
+}
+
+// Fetched JWKS documents
+var jwksCache = struct {
+	sync.Mutex
+	sets map[string]*cachedJWKS // URL -> JWKS
+}{sets: map[string]*cachedJWKS{}}
+
+type cachedJWKS struct {
+	set     jwk.Set
+	expires int64
+	fetched int64 // unix time of the last fetch
+}
+
+// Load a JWKS from a file or an http(s) URL
+// A fetched JWKS is cached according to its Cache-Control header. It is fetched again
+// before that if it does not have the key kid, the issuer may have rotated its keys.
+// A URL is fetched at most once per minJWKSRefetch, unknown kids cannot make it refetch in a loop.
+func loadJWKS(location string, kid string) (jwk.Set, error) {
+	if !strings.HasPrefix(location, "http://") && !strings.HasPrefix(location, "https://") {
+		return jwk.ReadFile(location)
+	}
+
+	now := time.Now().Unix()
+	jwksCache.Lock()
+	if cached, ok := jwksCache.sets[location]; ok {
+		_, found := cached.set.LookupKeyID(kid)
+		fresh := now < cached.expires && (kid == "" || found)
+		if fresh || now < cached.fetched+int64(minJWKSRefetch/time.Second) {
+			jwksCache.Unlock()
+			return cached.set, nil
+		}
+		// The other lookups use the cached set until the fetch completes
+		cached.fetched = now
+	}
+	jwksCache.Unlock()
+
+	// The HTTP request is made without holding the cache lock
+	set, expires, err := fetchJWKS(location)
+	if err != nil {
+		return nil, err
+	}
+	jwksCache.Lock()
+	jwksCache.sets[location] = &cachedJWKS{set: set, expires: expires, fetched: now}
+	jwksCache.Unlock()
+	return set, nil
+}
+
+// Fetch a JWKS and its expiry time
+func fetchJWKS(location string) (jwk.Set, int64, error) {
+	client := &http.Client{Timeout: defaultFetchTimeout}
+	resp, err := client.Get(location)
+	if err != nil {
+		return nil, 0, fmt.Errorf("%w: %w", ErrStatusListUnavailable, err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, 0, fmt.Errorf("failed to fetch the JWKS: %s", resp.Status)
+	}
+	data, err := io.ReadAll(io.LimitReader(resp.Body, maxStatusListSize))
+	if err != nil {
+		return nil, 0, err
+	}
+	set, err := jwk.Parse(data)
+	if err != nil {
+		return nil, 0, err
+	}
+	return set, expiresAt(resp.Header, time.Now().Add(defaultJWKSLifetime).Unix()), nil
+}
+
+// StatusURLOf reads the status list distribution point (sdb) of a credential file
+// The sdb claim of the credential is used, or the one of the detached status token
+func StatusURLOf(credentialPath string) (string, error) {
+	var jwtData JWTData
+	if err := LoadJSON(&jwtData, credentialPath); err != nil {
+		return "", err
+	}
+
+	for _, raw := range []string{jwtData.Jwt, jwtData.DetachedDsl} {
+		if raw == "" {
+			continue
+		}
+		t, err := jwt.Parse([]byte(raw), jwt.WithVerify(false), jwt.WithValidate(false))
+		if err != nil {
+			return "", err
+		}
+		var sdb string
+		if err := t.Get("sdb", &sdb); err == nil && sdb != "" {
+			return sdb, nil
+		}
+	}
+	return "", errors.New("the credential has no status list distribution point (sdb)")
+}
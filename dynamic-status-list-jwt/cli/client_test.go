@@ -0,0 +1,271 @@
+package main
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/lestrrat-go/jwx/v3/jwa"
+)
+
+// Issuer with a software ES256 key and no entries, kept in memory
+func newTestServer(t testing.TB) *Server {
+	t.Helper()
+	key, err := softwareBackend{}.Generate(jwa.ES256(), 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	master := make([]byte, seedMasterSize)
+	if _, err := rand.Read(master); err != nil {
+		t.Fatal(err)
+	}
+	dsl := map[string]DslEntry{}
+	return &Server{
+		Config:  DefaultListConfig(),
+		Dsl:     &dsl,
+		keys:    &KeyRing{Keys: []*IssuerKey{key}},
+		backend: softwareBackend{},
+		seedKey: seedKeyOf(master),
+	}
+}
+
+// Publish the previous, current and next windows around now
+func publishTestWindows(t testing.TB, s *Server) int64 {
+	t.Helper()
+	period := s.Config.Period
+	nbf := DslWindowStart(time.Now().Unix(), period)
+	s.DslWindows = nil
+	for _, start := range []int64{nbf - period, nbf, nbf + period} {
+		signed, err := s.signDslJwt(start, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		s.DslWindows = append(s.DslWindows, DslJWT{DslJwt: string(signed), Nbf: start})
+	}
+	return nbf
+}
+
+// Distribution point serving the published windows with a fixed Cache-Control header
+type testListServer struct {
+	s            *Server
+	cacheControl string
+
+	mu       sync.Mutex
+	requests []string // query of every request
+	notMod   int      // 304 responses
+}
+
+func (ts *testListServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	ts.mu.Lock()
+	defer ts.mu.Unlock()
+	ts.requests = append(ts.requests, r.URL.RawQuery)
+
+	query := r.URL.Query()
+	nbf, err := strconv.ParseInt(query.Get("nbf"), 10, 64)
+	if query.Has("t") {
+		var t int64
+		t, err = strconv.ParseInt(query.Get("t"), 10, 64)
+		nbf = DslWindowStart(t, ts.s.Config.Period)
+	}
+	if err != nil {
+		http.Error(w, "invalid query", http.StatusBadRequest)
+		return
+	}
+	dsl, ok := ts.s.DslJwtByNbf(nbf)
+	if !ok {
+		http.NotFound(w, r)
+		return
+	}
+
+	digest := sha256.Sum256([]byte(dsl.DslJwt))
+	etag := `"` + hex.EncodeToString(digest[:16]) + `"`
+	w.Header().Set("ETag", etag)
+	w.Header().Set("Cache-Control", ts.cacheControl)
+	if r.Header.Get("If-None-Match") == etag {
+		ts.notMod++
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+	json.NewEncoder(w).Encode(dsl)
+}
+
+func (ts *testListServer) count() (int, int) {
+	ts.mu.Lock()
+	defer ts.mu.Unlock()
+	return len(ts.requests), ts.notMod
+}
+
+func startTestListServer(t *testing.T, cacheControl string) (*testListServer, *httptest.Server, int64) {
+	t.Helper()
+	ts := &testListServer{s: newTestServer(t), cacheControl: cacheControl}
+	nbf := publishTestWindows(t, ts.s)
+	srv := httptest.NewServer(ts)
+	t.Cleanup(srv.Close)
+	return ts, srv, nbf
+}
+
+func TestStatusClientRevalidatesWithETag(t *testing.T) {
+	ts, srv, nbf := startTestListServer(t, "max-age=0")
+	c := NewStatusClient(VerifyOptions{}, "")
+	now := time.Now().Unix()
+
+	first, stale, err := c.StatusList(srv.URL, now)
+	if err != nil || stale {
+		t.Fatalf("first fetch: stale %v, err %v", stale, err)
+	}
+	if first.Nbf != nbf {
+		t.Fatalf("got window %d, want %d", first.Nbf, nbf)
+	}
+	// The current window and the prefetched next window
+	if n, _ := ts.count(); n != 2 {
+		t.Fatalf("got %d requests, want 2", n)
+	}
+
+	second, stale, err := c.StatusList(srv.URL, now)
+	if err != nil || stale {
+		t.Fatalf("revalidation: stale %v, err %v", stale, err)
+	}
+	if second != first {
+		t.Fatal("a revalidated list must not be parsed again")
+	}
+	if _, notMod := ts.count(); notMod != 2 {
+		t.Fatalf("got %d 304 responses, want 2", notMod)
+	}
+}
+
+func TestStatusClientCacheControl(t *testing.T) {
+	for _, tc := range []struct {
+		cacheControl string
+		requests     int // after two lookups of the current window
+	}{
+		{"max-age=3600", 2}, // fresh until nxt, only the first lookup fetches
+		{"max-age=0", 4},    // revalidated on every lookup
+		{"no-store", 4},
+	} {
+		t.Run(tc.cacheControl, func(t *testing.T) {
+			ts, srv, _ := startTestListServer(t, tc.cacheControl)
+			c := NewStatusClient(VerifyOptions{}, "")
+			now := time.Now().Unix()
+			for range 2 {
+				if _, _, err := c.StatusList(srv.URL, now); err != nil {
+					t.Fatal(err)
+				}
+			}
+			if n, _ := ts.count(); n != tc.requests {
+				t.Fatalf("got %d requests, want %d", n, tc.requests)
+			}
+		})
+	}
+}
+
+func TestStatusClientConcurrentFetch(t *testing.T) {
+	ts, srv, nbf := startTestListServer(t, "max-age=3600")
+	c := NewStatusClient(VerifyOptions{}, "")
+	now := time.Now().Unix()
+
+	var wg sync.WaitGroup
+	errs := make(chan error, 20)
+	for range 20 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			list, _, err := c.StatusList(srv.URL, now)
+			if err == nil && list.Nbf != nbf {
+				err = fmt.Errorf("got window %d, want %d", list.Nbf, nbf)
+			}
+			errs <- err
+		}()
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	// The lookups wait for a single request of the current window, and the next one is prefetched once
+	if n, _ := ts.count(); n != 2 {
+		t.Fatalf("got %d requests, want 2", n)
+	}
+}
+
+func TestStatusClientOffline(t *testing.T) {
+	ts, srv, nbf := startTestListServer(t, "max-age=0")
+	period := ts.s.Config.Period
+	cacheDir := t.TempDir()
+	c := NewStatusClient(VerifyOptions{}, cacheDir)
+	now := time.Now().Unix()
+	if _, _, err := c.StatusList(srv.URL, now); err != nil {
+		t.Fatal(err)
+	}
+	srv.Close()
+
+	// The cached windows are used, from memory and from the disk cache
+	for name, client := range map[string]*StatusClient{"memory": c, "disk": NewStatusClient(VerifyOptions{}, cacheDir)} {
+		list, stale, err := client.StatusList(srv.URL, now)
+		if err != nil || !stale || list.Nbf != nbf {
+			t.Fatalf("%s, current window: stale %v, err %v", name, stale, err)
+		}
+
+		// The prefetched next window verifies during the next period
+		list, stale, err = client.StatusList(srv.URL, nbf+period)
+		if err != nil || !stale || list.Nbf != nbf+period {
+			t.Fatalf("%s, next window: stale %v, err %v", name, stale, err)
+		}
+		if err := ValidateDslJWT(list.Token, VerifyOptions{Now: time.Unix(nbf+period, 0)}); err != nil {
+			t.Fatalf("%s, next window: %v", name, err)
+		}
+
+		// Later windows are not available
+		if _, _, err := client.StatusList(srv.URL, nbf+2*period); err == nil {
+			t.Fatalf("%s: a window after the prefetched one must not be available offline", name)
+		}
+	}
+}
+
+func TestLoadJWKSRefetchInterval(t *testing.T) {
+	s := newTestServer(t)
+	key, err := s.signingKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var mu sync.Mutex
+	requests := 0
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		requests++
+		mu.Unlock()
+		jwks, err := s.keyRing().JWKS(time.Now().Unix())
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Cache-Control", "max-age=0")
+		json.NewEncoder(w).Encode(jwks)
+	}))
+	defer srv.Close()
+
+	// Expired sets and unknown kids are fetched again at most once per interval
+	for _, kid := range []string{key.Kid, key.Kid, "unknown", "unknown"} {
+		set, err := loadJWKS(srv.URL, kid)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, found := set.LookupKeyID(key.Kid); !found {
+			t.Fatalf("the JWKS does not have the key %s", key.Kid)
+		}
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	if requests != 1 {
+		t.Fatalf("got %d requests, want 1", requests)
+	}
+}
@@ -3,21 +3,75 @@ package main
 import (
 	"fmt"
 	"os"
+	"time"
 
 	"github.com/spf13/cobra"
 )
 
+// Annotation of the commands that need the issuer keys and the seed master
+// "load" loads them, generated on first use; "restore" only opens the key backend, the command replaces them
+const issuerCommandAnnotation = "issuer"
+
+// Issuer annotation of the command, or of the command group it belongs to
+func issuerCommand(cmd *cobra.Command) string {
+	for c := cmd; c != nil; c = c.Parent() {
+		if mode := c.Annotations[issuerCommandAnnotation]; mode != "" {
+			return mode
+		}
+	}
+	return ""
+}
+
 func (s *Server) Run() {
 	// CMD variables
 	var (
-		out             string
-		in              string
-		detached        bool
-		jti             string
-		revoked         bool
-		timestamp       int64
-		statusListPath  string
-		holderProofPath string
+		out                    string
+		in                     string
+		detached               bool
+		jti                    string
+		revoked                bool
+		suspended              bool
+		timestamp              int64
+		statusListPath         string
+		holderProofPaths       []string
+		listURL                string
+		cacheDir               string
+		fetch                  bool
+		listenPort             string
+		period                 int64
+		issuer                 string
+		servePeriod            int64
+		verifyOpts             VerifyOptions
+		proofOpts              ProofOptions
+		holderMasterKey        string
+		allowPlaintextMetadata bool
+		allowPlaintextKeystore bool
+		reason                 string
+		suspendReason          string
+		reinstateReason        string
+		statusMeta             StatusMetadata
+		encryptMetadata        bool
+		store                  string
+		storePath              string
+		padding                PaddingPolicy
+		encoding               string
+		truncateBytes          int
+		fpRate                 float64
+		revocationOnly         bool
+		workers                int
+		seedMode               string
+		algorithm              string
+		keyBackend             string
+		pkcs11Config           PKCS11Config
+		passphraseFile         string
+		backupPath             string
+		threshold              int
+		shareCount             int
+		sharePrefix            string
+		sharePaths             []string
+		force                  bool
+		prepublication         time.Duration
+		keyRetention           time.Duration
 	)
 
 	rootCmd := &cobra.Command{
@@ -25,7 +79,22 @@ func (s *Server) Run() {
 		Short: "CLI tool for managing dSL revocation",
 		Long: `A command-line tool to issue, print, and revoke 
 verifiable credentials using JSON Web Tokens (JWT).`,
+		// Only the issuer commands unlock the keystore, the holder and verifier commands never read it
+		PersistentPreRun: func(cmd *cobra.Command, args []string) {
+			var err error
+			switch issuerCommand(cmd) {
+			case "load":
+				err = s.LoadIssuer(allowPlaintextKeystore)
+			case "restore":
+				err = s.OpenIssuerBackend(allowPlaintextKeystore)
+			}
+			if err != nil {
+				fmt.Println("[ERROR]", err)
+				os.Exit(1)
+			}
+		},
 	}
+	rootCmd.PersistentFlags().BoolVar(&allowPlaintextKeystore, "allow-plaintext-keystore", false, "Store new issuer keys unencrypted instead of asking for a keystore passphrase")
 
 	// Issue a mock JWT and store it to a file
 	// Default filename: mock-jwt.json
@@ -34,11 +103,12 @@ verifiable credentials using JSON Web Tokens (JWT).`,
 		Short: "Issue a mock JWT",
 		Run: func(cmd *cobra.Command, args []string) {
 			fmt.Println("> Issuing a mock JWT")
-			s.IssueJWT(out)
+			s.IssueJWT(out, proofOpts.HolderKey)
 			fmt.Printf("> Mock JWT issued and stored to %s\n", out)
 		},
 	}
 	issueCmd.Flags().StringVarP(&out, "out", "o", "mock-jwt.json", "Path to the output file")
+	issueCmd.Flags().StringVar(&proofOpts.HolderKey, "holder-key", holderKeyFile, "Holder key the JWT is bound to, created if missing")
 
 	// Create new status list entry
 	newCmd := &cobra.Command{
@@ -46,17 +116,19 @@ verifiable credentials using JSON Web Tokens (JWT).`,
 		Short: "Create a new Status List entry",
 		Run: func(cmd *cobra.Command, args []string) {
 			fmt.Printf("> Creating a new status list entry for JWT: %s\n", in)
-			err := s.NewDslEntry(in, detached)
+			err := s.NewDslEntry(in, detached, holderMasterKey, allowPlaintextMetadata)
 			if err != nil {
 				fmt.Println("[ERROR]", err)
 				return
 			}
-			fmt.Println("> New status list entry created and stored in dsl.json. JWT jti entries are in dsl-map.json")
+			fmt.Println("> New status list entry created and stored in dsl.json. JWT jti entries are in the issuer store")
 		},
 	}
 	newCmd.Flags().StringVarP(&in, "in", "i", "", "Path to the JWT that will be added to the dSL")
 	newCmd.MarkFlagRequired("in")
 	newCmd.Flags().BoolVarP(&detached, "detached", "d", false, "Create a detached revocation metadata JWT.")
+	newCmd.Flags().StringVar(&holderMasterKey, "holder-master-key", "", "Registered holder public key the private metadata is encrypted to (default: the cnf key of the JWT)")
+	newCmd.Flags().BoolVar(&allowPlaintextMetadata, "allow-plaintext-metadata", false, "Write the private metadata in plaintext if the JWT is not bound to a holder key")
 
 	// New revocation metadata (proof) command
 	proofCmd := &cobra.Command{
@@ -64,7 +136,21 @@ verifiable credentials using JSON Web Tokens (JWT).`,
 		Short: "Derive status list identifier (holder/wallet)",
 		Run: func(cmd *cobra.Command, args []string) {
 			fmt.Println("> Deriving status list identifier")
-			identifier, err := NewProof(in, revoked, detached, timestamp)
+			status := StatusValid
+			if revoked {
+				status = StatusRevoked
+			} else if suspended {
+				status = StatusSuspended
+			}
+			if fetch && listURL == "" {
+				var err error
+				listURL, err = StatusURLOf(in)
+				if err != nil {
+					fmt.Println("[ERROR]", err)
+					return
+				}
+			}
+			identifier, err := NewProof(in, statusListPath, listURL, status, detached, timestamp, proofOpts, verifyOpts, cacheDir)
 			if err != nil {
 				fmt.Println("[ERROR]", err)
 				return
@@ -76,8 +162,71 @@ verifiable credentials using JSON Web Tokens (JWT).`,
 	proofCmd.Flags().StringVarP(&in, "in", "i", "", "Path to the file to derive the identifier from")
 	proofCmd.MarkFlagRequired("in")
 	proofCmd.Flags().BoolVarP(&revoked, "revoked", "r", false, "Create a proof for a revoked credential")
+	proofCmd.Flags().BoolVar(&suspended, "suspended", false, "Create a proof for a suspended credential")
 	proofCmd.Flags().BoolVarP(&detached, "detached", "d", false, "Create a detached revocation token")
 	proofCmd.Flags().Int64VarP(&timestamp, "timestamp", "t", 0, "Unix timestamp when the holder computes the identifier")
+	proofCmd.Flags().StringVarP(&statusListPath, "status-list", "s", "dsl.json", "Path to the status list the dSL period is read from, its signature is verified")
+	proofCmd.Flags().BoolVarP(&fetch, "fetch", "f", false, "Fetch the status list from the sdb of the credential")
+	proofCmd.Flags().StringVar(&listURL, "url", "", "Fetch the status list from this URL")
+	proofCmd.Flags().StringVar(&cacheDir, "cache-dir", defaultCacheDir, "Cache of the fetched status lists, empty disables it")
+	proofCmd.Flags().StringVar(&verifyOpts.IssuerJWK, "issuer-jwk", "", "Path to the trusted issuer JWK")
+	proofCmd.Flags().StringVar(&verifyOpts.IssuerJWKS, "issuer-jwks", "", "Path or URL of a JWKS with the trusted issuer keys")
+	proofCmd.Flags().StringVar(&verifyOpts.Issuer, "issuer", "", "Expected iss (issuer id) when the embedded jwk header is used")
+	proofCmd.Flags().StringVar(&proofOpts.HolderKey, "holder-key", holderKeyFile, "Holder key the private metadata is decrypted and the proof is signed with")
+	proofCmd.Flags().StringVar(&proofOpts.MasterKey, "holder-master-key", "", "Holder master key the private metadata is decrypted with, if it was encrypted to it")
+	proofCmd.Flags().StringVar(&proofOpts.Audience, "aud", "", "Verifier the proof is intended for")
+	proofCmd.Flags().StringVar(&proofOpts.Nonce, "nonce", "", "Nonce provided by the verifier")
+	proofCmd.Flags().BoolVar(&proofOpts.AllowPlaintextMetadata, "allow-plaintext-metadata", false, "Accept private metadata that is not encrypted to the holder")
+
+	// Check the holder's own credential status
+	walletStatusCmd := &cobra.Command{
+		Use:   "status",
+		Short: "Check the status of the holder's credential",
+		Run: func(cmd *cobra.Command, args []string) {
+			if fetch && listURL == "" {
+				var err error
+				listURL, err = StatusURLOf(in)
+				if err != nil {
+					fmt.Println("[ERROR]", err)
+					return
+				}
+			}
+			if listURL != "" {
+				fmt.Printf("> Fetching the status list from %s\n", listURL)
+			}
+			result, err := WalletStatus(in, proofOpts, statusListPath, listURL, verifyOpts, cacheDir)
+			if err != nil {
+				fmt.Println("[ERROR]", err)
+				return
+			}
+			if result.Stale {
+				fmt.Println("> The issuer is unreachable, using the cached status list")
+			}
+			fmt.Printf("> Credential status: %s\n", result.Status)
+			if result.Metadata != nil {
+				PrintStatusInfo(*result.Metadata)
+			}
+			switch result.Status {
+			case StatusRevoked:
+				fmt.Println("[WARNING] The credential is revoked, do not present it")
+			case StatusSuspended:
+				fmt.Println("[WARNING] The credential is suspended, verifiers will reject it until it is reinstated")
+			}
+		},
+	}
+	walletStatusCmd.Flags().StringVarP(&in, "in", "i", "", "Path to the credential file")
+	walletStatusCmd.MarkFlagRequired("in")
+	walletStatusCmd.Flags().StringVarP(&statusListPath, "status-list", "s", "dsl.json", "Path to the status list")
+	walletStatusCmd.Flags().StringVar(&proofOpts.HolderKey, "holder-key", holderKeyFile, "Holder key the private metadata is decrypted with")
+	walletStatusCmd.Flags().StringVar(&proofOpts.MasterKey, "holder-master-key", "", "Holder master key the private metadata is decrypted with, if it was encrypted to it")
+	walletStatusCmd.Flags().BoolVar(&proofOpts.AllowPlaintextMetadata, "allow-plaintext-metadata", false, "Accept private metadata that is not encrypted to the holder")
+	walletStatusCmd.Flags().BoolVarP(&fetch, "fetch", "f", false, "Fetch the status list from the sdb of the credential")
+	walletStatusCmd.Flags().StringVar(&listURL, "url", "", "Fetch the status list from this URL")
+	walletStatusCmd.Flags().StringVar(&cacheDir, "cache-dir", defaultCacheDir, "Cache of the fetched status lists, empty disables it")
+	walletStatusCmd.Flags().StringVar(&verifyOpts.IssuerJWK, "issuer-jwk", "", "Path to the trusted issuer JWK")
+	walletStatusCmd.Flags().StringVar(&verifyOpts.IssuerJWKS, "issuer-jwks", "", "Path or URL of a JWKS with the trusted issuer keys")
+	walletStatusCmd.Flags().StringVar(&verifyOpts.Issuer, "issuer", "", "Expected iss (issuer id) when the embedded jwk header is used")
+	proofCmd.AddCommand(walletStatusCmd)
 
 	// Recompute DSL command
 	recomputeCmd := &cobra.Command{
@@ -106,7 +255,13 @@ verifiable credentials using JSON Web Tokens (JWT).`,
 		Short: "Revoke a JWT",
 		Run: func(cmd *cobra.Command, args []string) {
 			fmt.Printf("> Revoking JWT with jti: %s\n", jti)
-			err := s.Revoke(jti)
+			code, err := ParseReasonCode(reason)
+			if err != nil {
+				fmt.Println("[ERROR]", err)
+				return
+			}
+			statusMeta.Reason = code
+			err = s.Revoke(jti, statusMeta)
 			if err != nil {
 				fmt.Println("[ERROR]", err)
 				return
@@ -115,24 +270,389 @@ verifiable credentials using JSON Web Tokens (JWT).`,
 		},
 	}
 	revokeCmd.Flags().StringVarP(&jti, "jti", "j", "", "JTI of the JWT to revoke")
+	revokeCmd.Flags().StringVarP(&reason, "reason", "r", "unspecified", "RFC 5280 revocation reason (name or code), e.g. keyCompromise or 1")
+	revokeCmd.Flags().StringVarP(&statusMeta.Actor, "actor", "a", "", "Identity of the operator revoking the JWT")
+	revokeCmd.Flags().StringVarP(&statusMeta.Note, "note", "n", "", "Free-text note")
+	revokeCmd.Flags().Int64VarP(&statusMeta.Time, "time", "t", 0, "Unix time of the revocation (default: now)")
+
+	// Suspend JWT command
+	suspendCmd := &cobra.Command{
+		Use:   "suspend",
+		Short: "Suspend a JWT until it is reinstated",
+		Run: func(cmd *cobra.Command, args []string) {
+			fmt.Printf("> Suspending JWT with jti: %s\n", jti)
+			code, err := ParseReasonCode(suspendReason)
+			if err != nil {
+				fmt.Println("[ERROR]", err)
+				return
+			}
+			statusMeta.Reason = code
+			err = s.Suspend(jti, statusMeta)
+			if err != nil {
+				fmt.Println("[ERROR]", err)
+				return
+			}
+			fmt.Println("> JWT successfully suspended. DSL stored in dsl.json")
+		},
+	}
+	suspendCmd.Flags().StringVarP(&jti, "jti", "j", "", "JTI of the JWT to suspend")
+	suspendCmd.Flags().StringVarP(&suspendReason, "reason", "r", "certificateHold", "RFC 5280 reason (name or code)")
+	suspendCmd.Flags().StringVarP(&statusMeta.Actor, "actor", "a", "", "Identity of the operator suspending the JWT")
+	suspendCmd.Flags().StringVarP(&statusMeta.Note, "note", "n", "", "Free-text note")
+	suspendCmd.Flags().Int64VarP(&statusMeta.Time, "time", "t", 0, "Unix time of the suspension (default: now)")
+
+	// Reinstate JWT command
+	reinstateCmd := &cobra.Command{
+		Use:   "reinstate",
+		Short: "Reinstate a suspended JWT",
+		Run: func(cmd *cobra.Command, args []string) {
+			fmt.Printf("> Reinstating JWT with jti: %s\n", jti)
+			code, err := ParseReasonCode(reinstateReason)
+			if err != nil {
+				fmt.Println("[ERROR]", err)
+				return
+			}
+			statusMeta.Reason = code
+			err = s.Reinstate(jti, statusMeta)
+			if err != nil {
+				fmt.Println("[ERROR]", err)
+				return
+			}
+			fmt.Println("> JWT successfully reinstated. DSL stored in dsl.json")
+		},
+	}
+	reinstateCmd.Flags().StringVarP(&jti, "jti", "j", "", "JTI of the JWT to reinstate")
+	reinstateCmd.Flags().StringVarP(&reinstateReason, "reason", "r", "removeFromCRL", "RFC 5280 reason (name or code)")
+	reinstateCmd.Flags().StringVarP(&statusMeta.Actor, "actor", "a", "", "Identity of the operator reinstating the JWT")
+	reinstateCmd.Flags().StringVarP(&statusMeta.Note, "note", "n", "", "Free-text note")
+	reinstateCmd.Flags().Int64VarP(&statusMeta.Time, "time", "t", 0, "Unix time of the reinstatement (default: now)")
 
 	// Verify proof command
 	verifyCmd := &cobra.Command{
 		Use:   "verify",
 		Short: "Verify the holder's proof",
 		Run: func(cmd *cobra.Command, args []string) {
-			fmt.Printf("> Verifying proof: %s\n", holderProofPath)
-			revoked, err := Verify(statusListPath, holderProofPath)
+			// The status list is loaded once for all proofs
+			var results []ProofResult
+			if listURL != "" || verifyOpts.Credential != "" && !cmd.Flags().Changed("status-list") {
+				// Fetch the list from the distribution point of the credential
+				if listURL == "" {
+					var err error
+					listURL, err = StatusURLOf(verifyOpts.Credential)
+					if err != nil {
+						fmt.Println("[ERROR]", err)
+						return
+					}
+				}
+				fmt.Printf("> Fetching the status list from %s\n", listURL)
+				results = VerifyRemote(listURL, holderProofPaths, verifyOpts, cacheDir)
+			} else {
+				var err error
+				results, err = VerifyAll(statusListPath, holderProofPaths, verifyOpts)
+				if err != nil {
+					fmt.Println("[ERROR]", err)
+					return
+				}
+			}
+			for _, r := range results {
+				fmt.Printf("> Verifying proof: %s\n", r.Path)
+				if r.Err != nil {
+					fmt.Println("[ERROR]", r.Err)
+					continue
+				}
+				if r.Result.Stale {
+					fmt.Println("> The issuer is unreachable, using the cached status list")
+				}
+				fmt.Printf("> Proof successfully verified. Status: %s\n", r.Result.Status)
+				if r.Result.Metadata != nil {
+					PrintStatusInfo(*r.Result.Metadata)
+				}
+			}
+		},
+	}
+	verifyCmd.Flags().StringVarP(&statusListPath, "status-list", "s", "dsl.json", "Path to the status list")
+	verifyCmd.Flags().StringSliceVarP(&holderProofPaths, "holder-proof", "p", []string{"holder_status-list-identifier.json"}, "Path to the holder's proof, repeat to check several proofs")
+	verifyCmd.Flags().StringVarP(&jti, "jti", "j", "", "JTI of the JWT to verify")
+	verifyCmd.Flags().StringVar(&verifyOpts.IssuerJWK, "issuer-jwk", "", "Path to the trusted issuer JWK")
+	verifyCmd.Flags().StringSliceVar(&verifyOpts.Algorithms, "alg", supportedAlgorithms, "Accepted signature algorithms")
+	verifyCmd.Flags().StringVar(&verifyOpts.IssuerJWKS, "issuer-jwks", "", "Path or URL of a JWKS with the trusted issuer keys")
+	verifyCmd.Flags().StringVar(&verifyOpts.Issuer, "issuer", "", "Expected iss (issuer id) when the embedded jwk header is used")
+	verifyCmd.Flags().DurationVar(&verifyOpts.ClockSkew, "clock-skew", defaultClockSkew, "Tolerated clock skew for nbf, exp and nxt")
+	verifyCmd.Flags().DurationVar(&verifyOpts.MaxStale, "max-stale", 0, "Accept expired or superseded lists for this long, e.g. when the issuer is offline")
+	verifyCmd.Flags().StringVarP(&verifyOpts.Credential, "credential", "c", "", "Credential the proof is for, its cnf key verifies the proof and its sdb is fetched")
+	verifyCmd.Flags().StringVar(&verifyOpts.Audience, "aud", "", "Expected audience of the proof (this verifier), required for signed proofs")
+	verifyCmd.Flags().StringVar(&verifyOpts.Nonce, "nonce", "", "Expected nonce of the proof, required for signed proofs")
+	verifyCmd.Flags().DurationVar(&verifyOpts.MaxProofAge, "max-proof-age", defaultProofMaxAge, "Oldest accepted proof")
+	verifyCmd.Flags().BoolVar(&verifyOpts.AllowUnsignedProof, "allow-unsigned", false, "Accept unsigned proofs, they can be replayed to other verifiers")
+	verifyCmd.Flags().BoolVar(&verifyOpts.AllowUnboundProof, "allow-unbound-proof", false, "Accept signed proofs without checking their audience and nonce, they can be replayed until they expire")
+	verifyCmd.Flags().StringVar(&listURL, "url", "", "Fetch the status list from this URL")
+	verifyCmd.Flags().StringVar(&cacheDir, "cache-dir", defaultCacheDir, "Cache of the fetched status lists, empty disables it")
+
+	// Serve the DSL over HTTP
+	serveCmd := &cobra.Command{
+		Use:   "serve",
+		Short: "Serve the DSL at the status list distribution point",
+		Run: func(cmd *cobra.Command, args []string) {
+			fmt.Println("> Starting the status list distribution point")
+			err := s.Serve(host+":"+listenPort, time.Duration(servePeriod)*time.Second)
 			if err != nil {
 				fmt.Println("[ERROR]", err)
 				return
 			}
-			fmt.Printf("> Proof successfully verified. Revoked: %t\n", revoked)
 		},
 	}
-	verifyCmd.Flags().StringVarP(&statusListPath, "status-list", "s", "dsl.json", "Path to the status list")
-	verifyCmd.Flags().StringVarP(&holderProofPath, "holder-proof", "p", "holder_status-list-identifier.json", "Path to the holder's proof")
-	verifyCmd.Flags().StringVarP(&jti, "jti", "j", "", "JTI of the JWT to verify")
+	serveCmd.Flags().StringVarP(&listenPort, "port", "p", port, "Port to listen on")
+	serveCmd.Flags().Int64Var(&servePeriod, "period", s.Config.Period, "Recompute period in seconds (defaults to the status list period)")
+
+	// Status list configuration
+	configCmd := &cobra.Command{
+		Use:   "config",
+		Short: "Show or update the status list configuration",
+		Run: func(cmd *cobra.Command, args []string) {
+			config := s.Config
+			if cmd.Flags().Changed("period") {
+				fmt.Printf("> Setting the dSL period to %d seconds\n", period)
+				config.Period = period
+			}
+			if cmd.Flags().Changed("issuer") {
+				fmt.Printf("> Setting the issuer id to %q\n", issuer)
+				config.Issuer = issuer
+			}
+			if cmd.Flags().Changed("encrypt-metadata") {
+				fmt.Printf("> Setting encrypted status metadata to %t\n", encryptMetadata)
+				config.EncryptMetadata = encryptMetadata
+			}
+			if cmd.Flags().Changed("padding") {
+				fmt.Printf("> Setting the padding mode to %s\n", padding.Mode)
+				config.Padding.Mode = padding.Mode
+			}
+			if cmd.Flags().Changed("padding-size") {
+				config.Padding.Size = padding.Size
+			}
+			if cmd.Flags().Changed("encoding") {
+				fmt.Printf("> Setting the encoding to %s\n", encoding)
+				config.Encoding = encoding
+			}
+			if cmd.Flags().Changed("truncate-bytes") {
+				config.TruncateBytes = truncateBytes
+			}
+			if cmd.Flags().Changed("false-positive-rate") {
+				config.FalsePositiveRate = fpRate
+			}
+			if cmd.Flags().Changed("revocation-only") {
+				config.RevocationOnly = revocationOnly
+			}
+			if cmd.Flags().Changed("algorithm") {
+				fmt.Printf("> Setting the signature algorithm to %s\n", algorithm)
+				config.Algorithm = algorithm
+			}
+			if cmd.Flags().Changed("key-backend") {
+				fmt.Printf("> Setting the key backend to %s\n", keyBackend)
+				config.KeyBackend = keyBackend
+			}
+			if cmd.Flags().Changed("pkcs11-module") || cmd.Flags().Changed("pkcs11-token") {
+				token := PKCS11Config{}
+				if config.PKCS11 != nil {
+					token = *config.PKCS11
+				}
+				if cmd.Flags().Changed("pkcs11-module") {
+					token.Module = pkcs11Config.Module
+				}
+				if cmd.Flags().Changed("pkcs11-token") {
+					token.Token = pkcs11Config.Token
+				}
+				config.PKCS11 = &token
+			}
+			if cmd.Flags().Changed("seed-mode") {
+				fmt.Printf("> Setting the seed mode of new entries to %s\n", seedMode)
+				config.SeedMode = seedMode
+			}
+			if cmd.Flags().Changed("workers") {
+				config.Workers = workers
+			}
+			if cmd.Flags().Changed("store") {
+				fmt.Printf("> Setting the store to %s (used from the next command on)\n", store)
+				config.Store = store
+			}
+			if cmd.Flags().Changed("store-path") {
+				config.StorePath = storePath
+			}
+			if config != s.Config {
+				rotate := config.SigningAlgorithm() != s.Config.SigningAlgorithm() || config.KeyBackend != s.Config.KeyBackend
+				err := s.UpdateConfig(config)
+				if err != nil {
+					fmt.Println("[ERROR]", err)
+					return
+				}
+				// Sign with a key of the new algorithm or in the new backend from now on
+				if rotate {
+					key, err := s.RotateKeys(defaultKeyPrepublication, defaultKeyRetention)
+					if err != nil {
+						fmt.Println("[ERROR]", err)
+						return
+					}
+					fmt.Printf("> New issuer key %s (%s, %s), the previous key is retired\n", key.Kid, key.alg, key.Backend())
+				}
+			}
+			fmt.Printf("> Status list configuration (%s):\n", listConfigFile)
+			Print(listConfigFile)
+		},
+	}
+	configCmd.Flags().Int64Var(&period, "period", 0, "dSL period in seconds")
+	configCmd.Flags().StringVar(&issuer, "issuer", "", "Issuer id (iss) of the lists and credentials, the first key's thumbprint if empty")
+	configCmd.Flags().BoolVar(&encryptMetadata, "encrypt-metadata", false, "Publish the status metadata encrypted for the holder")
+	configCmd.Flags().StringVar(&padding.Mode, "padding", PaddingNone, "Decoy padding of the list: none, pow2, bucket or min")
+	configCmd.Flags().IntVar(&padding.Size, "padding-size", 0, "Bucket size (bucket) or minimum number of identifiers (min)")
+	configCmd.Flags().StringVar(&encoding, "encoding", EncodingList, "Encoding of the status identifiers: list, truncated, bloom or cascade")
+	configCmd.Flags().IntVar(&truncateBytes, "truncate-bytes", defaultTruncateBytes, "Identifier length of the truncated encoding in bytes")
+	configCmd.Flags().Float64Var(&fpRate, "false-positive-rate", defaultFalsePositiveRate, "False positive rate of the Bloom filter")
+	configCmd.Flags().BoolVar(&revocationOnly, "revocation-only", false, "Accept the bloom encoding, which proves revoked and suspended statuses only")
+	configCmd.Flags().StringVar(&algorithm, "algorithm", defaultAlgorithm, "Signature algorithm of the issuer keys: ES256, ES384, ES512, EdDSA or RS256")
+	configCmd.Flags().StringVar(&keyBackend, "key-backend", KeyBackendSoftware, "Backend of the private issuer keys: software or pkcs11")
+	configCmd.Flags().StringVar(&pkcs11Config.Module, "pkcs11-module", "", "Path of the PKCS#11 library of the pkcs11 key backend")
+	configCmd.Flags().StringVar(&pkcs11Config.Token, "pkcs11-token", "", "Token label of the pkcs11 key backend")
+	configCmd.Flags().StringVar(&seedMode, "seed-mode", SeedModeSecret, "Seed derivation of new entries: secret or arkg")
+	configCmd.Flags().IntVar(&workers, "workers", 0, "Number of workers that recompute the list, 0 uses all CPUs")
+	configCmd.Flags().StringVar(&store, "store", StoreFile, "Storage backend of the issuer state: file or bolt")
+	configCmd.Flags().StringVar(&storePath, "store-path", defaultBoltPath, "Database path of the bolt store")
+
+	// Issuer key management
+	keysCmd := &cobra.Command{
+		Use:   "keys",
+		Short: "Manage the issuer signing keys",
+	}
+	keysListCmd := &cobra.Command{
+		Use:   "list",
+		Short: "List the issuer keys and their schedule",
+		Run: func(cmd *cobra.Command, args []string) {
+			s.keyRing().Print()
+		},
+	}
+	keysRotateCmd := &cobra.Command{
+		Use:   "rotate",
+		Short: "Add a new issuer key and retire the current one",
+		Run: func(cmd *cobra.Command, args []string) {
+			fmt.Println("> Rotating the issuer keys")
+			key, err := s.RotateKeys(prepublication, keyRetention)
+			if err != nil {
+				fmt.Println("[ERROR]", err)
+				return
+			}
+			fmt.Printf("> New issuer key %s signs from %s\n", key.Kid, time.Unix(key.Activates, 0).UTC().Format(time.RFC3339))
+			s.keyRing().Print()
+			fmt.Printf("> Published keys stored in %s\n", jwksFile)
+		},
+	}
+	keysRotateCmd.Flags().DurationVar(&prepublication, "prepublish", defaultKeyPrepublication, "Publish the new key this long before it signs")
+	keysRotateCmd.Flags().DurationVar(&keyRetention, "retention", defaultKeyRetention, "Keep the retired keys in the JWKS this long")
+	keysEncryptCmd := &cobra.Command{
+		Use:   "encrypt",
+		Short: "Encrypt the issuer keys and the seed master with a new passphrase",
+		Run: func(cmd *cobra.Command, args []string) {
+			passphrase, err := newPassphrase(passphraseFile, "New keystore passphrase: ")
+			if err != nil {
+				fmt.Println("[ERROR]", err)
+				return
+			}
+			if err := s.EncryptKeystore(passphrase); err != nil {
+				fmt.Println("[ERROR]", err)
+				return
+			}
+			fmt.Printf("> Keystore encrypted, set %s or %s to use it without a prompt\n", keystorePassphraseEnv, keystorePassphraseFileEnv)
+		},
+	}
+	keysEncryptCmd.Flags().StringVar(&passphraseFile, "passphrase-file", "", "Read the new passphrase from a file instead of the terminal")
+
+	keysExportCmd := &cobra.Command{
+		Use:   "export",
+		Short: "Export an encrypted backup of the issuer keys and the seed master",
+		Run: func(cmd *cobra.Command, args []string) {
+			passphrase, err := newPassphrase(passphraseFile, "Backup passphrase: ")
+			if err != nil {
+				fmt.Println("[ERROR]", err)
+				return
+			}
+			if err := s.ExportKeys(backupPath, passphrase); err != nil {
+				fmt.Println("[ERROR]", err)
+				return
+			}
+			fmt.Printf("> Issuer keys and seed master exported to %s\n", backupPath)
+		},
+	}
+	keysExportCmd.Flags().StringVarP(&backupPath, "out", "o", "", "Path of the backup file")
+	keysExportCmd.Flags().StringVar(&passphraseFile, "passphrase-file", "", "Read the backup passphrase from a file instead of the terminal")
+	keysExportCmd.MarkFlagRequired("out")
+
+	keysImportCmd := &cobra.Command{
+		Use:   "import",
+		Short: "Restore the issuer keys and the seed master from a backup",
+		Run: func(cmd *cobra.Command, args []string) {
+			passphrase, err := existingPassphrase(passphraseFile, "Backup passphrase: ")
+			if err != nil {
+				fmt.Println("[ERROR]", err)
+				return
+			}
+			ring, err := s.ImportKeys(backupPath, passphrase, force)
+			if err != nil {
+				fmt.Println("[ERROR]", err)
+				return
+			}
+			fmt.Printf("> Issuer keys and seed master imported from %s\n", backupPath)
+			ring.Print()
+		},
+	}
+	keysImportCmd.Flags().StringVarP(&backupPath, "in", "i", "", "Path of the backup file")
+	keysImportCmd.Flags().StringVar(&passphraseFile, "passphrase-file", "", "Read the backup passphrase from a file instead of the terminal")
+	keysImportCmd.Flags().BoolVar(&force, "force", false, "Replace the stored keys even if registered entries derive their seeds from them")
+	keysImportCmd.MarkFlagRequired("in")
+	keysSplitCmd := &cobra.Command{
+		Use:   "split",
+		Short: "Split the issuer keys and the seed master into M-of-N Shamir shares",
+		Run: func(cmd *cobra.Command, args []string) {
+			shares, err := s.SplitKeys(threshold, shareCount)
+			if err != nil {
+				fmt.Println("[ERROR]", err)
+				return
+			}
+			for _, share := range shares {
+				path := fmt.Sprintf("%s-%d-of-%d.json", sharePrefix, share.Index, share.Shares)
+				if err := SaveJSON(share, path); err != nil {
+					fmt.Println("[ERROR]", err)
+					return
+				}
+				fmt.Printf("> Share %d stored in %s\n", share.Index, path)
+			}
+			fmt.Printf("> Any %d of the %d shares recover the keys (set %s)\n", threshold, shareCount, shares[0].Set)
+		},
+	}
+	keysSplitCmd.Flags().IntVarP(&threshold, "threshold", "m", 3, "Number of shares that recover the keys")
+	keysSplitCmd.Flags().IntVarP(&shareCount, "shares", "n", 5, "Number of shares")
+	keysSplitCmd.Flags().StringVarP(&sharePrefix, "out", "o", "dsl-share", "Path prefix of the share files")
+
+	keysRecoverCmd := &cobra.Command{
+		Use:   "recover",
+		Short: "Recover the issuer keys and the seed master from Shamir shares",
+		Run: func(cmd *cobra.Command, args []string) {
+			shares := make([]KeyShare, len(sharePaths))
+			for i, path := range sharePaths {
+				if err := LoadJSON(&shares[i], path); err != nil {
+					fmt.Println("[ERROR]", path, err)
+					return
+				}
+			}
+			ring, err := s.RecoverKeys(shares, force)
+			if err != nil {
+				fmt.Println("[ERROR]", err)
+				return
+			}
+			fmt.Printf("> Issuer keys and seed master recovered from %d shares\n", len(shares))
+			ring.Print()
+		},
+	}
+	keysRecoverCmd.Flags().StringSliceVarP(&sharePaths, "share", "s", nil, "Path to a share file (repeat for each share)")
+	keysRecoverCmd.Flags().BoolVar(&force, "force", false, "Replace the stored keys even if registered entries derive their seeds from them")
+	keysRecoverCmd.MarkFlagRequired("share")
+	keysCmd.AddCommand(keysListCmd, keysRotateCmd, keysEncryptCmd, keysExportCmd, keysImportCmd, keysSplitCmd, keysRecoverCmd)
 
 	// Print JSON information
 	printCmd := &cobra.Command{
@@ -159,7 +679,12 @@ verifiable credentials using JSON Web Tokens (JWT).`,
 	printJwtCmd.MarkFlagRequired("in")
 
 	// Add all subcommands to the root
-	rootCmd.AddCommand(issueCmd, newCmd, proofCmd, recomputeCmd, revokeCmd, printCmd, printJwtCmd, verifyCmd)
+	for _, cmd := range []*cobra.Command{issueCmd, newCmd, recomputeCmd, revokeCmd, suspendCmd, reinstateCmd, serveCmd, configCmd, keysCmd} {
+		cmd.Annotations = map[string]string{issuerCommandAnnotation: "load"}
+	}
+	keysImportCmd.Annotations = map[string]string{issuerCommandAnnotation: "restore"}
+	keysRecoverCmd.Annotations = map[string]string{issuerCommandAnnotation: "restore"}
+	rootCmd.AddCommand(issueCmd, newCmd, proofCmd, recomputeCmd, revokeCmd, suspendCmd, reinstateCmd, printCmd, printJwtCmd, verifyCmd, serveCmd, configCmd, keysCmd)
 
 	// Execute the root command
 	if err := rootCmd.Execute(); err != nil {
@@ -0,0 +1,183 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+)
+
+const (
+	listConfigFile   = "dsl-config.json"
+	defaultDslPeriod = int64(60) // default dSL time period in seconds
+)
+
+// ListConfig holds the configuration of a status list
+type ListConfig struct {
+	ID     string `json:"id"`     // status list identifier, served at /sdb/{id}
+	Period int64  `json:"period"` // dSL time period in seconds
+
+	// Issuer identifier (iss) of the lists and credentials, the thumbprint of the first issuer key if empty
+	Issuer string `json:"issuer,omitempty"`
+
+	// Publish the status metadata of every entry encrypted for the holder
+	EncryptMetadata bool `json:"encrypt_metadata"`
+
+	// Decoy identifiers that hide the number of entries
+	Padding PaddingPolicy `json:"padding"`
+
+	// Encoding of the status identifiers: "list" (default), "truncated", "bloom" or "cascade"
+	Encoding          string  `json:"encoding,omitempty"`
+	TruncateBytes     int     `json:"truncate_bytes,omitempty"`      // identifier length of the truncated encoding
+	FalsePositiveRate float64 `json:"false_positive_rate,omitempty"` // false positive rate of the Bloom filter
+	RevocationOnly    bool    `json:"revocation_only,omitempty"`     // accept the bloom encoding, which cannot prove a valid status
+
+	// Signature algorithm of the issuer keys: ES256 (default), ES384, ES512, EdDSA or RS256
+	Algorithm string `json:"algorithm,omitempty"`
+
+	// Backend of the private issuer keys: "software" (default) or "pkcs11"
+	KeyBackend string        `json:"key_backend,omitempty"`
+	PKCS11     *PKCS11Config `json:"pkcs11,omitempty"` // token of the pkcs11 backend
+
+	// Seed derivation of new entries: "secret" (default) or "arkg"
+	SeedMode string `json:"seed_mode,omitempty"`
+
+	// Number of workers that recompute the list, 0 uses all CPUs
+	Workers int `json:"workers,omitempty"`
+
+	// Storage backend of the issuer state: "file" (default) or "bolt"
+	Store     string `json:"store,omitempty"`
+	StorePath string `json:"store_path,omitempty"` // database path of the bolt store
+}
+
+// DefaultListConfig returns the configuration used when none is stored
+func DefaultListConfig() ListConfig {
+	return ListConfig{
+		ID:     statusListID,
+		Period: defaultDslPeriod,
+	}
+}
+
+// Validate checks the configuration values
+func (c ListConfig) Validate() error {
+	if c.ID == "" {
+		return errors.New("status list id must not be empty")
+	}
+	if c.Period <= 0 {
+		return fmt.Errorf("invalid dSL period %d: must be a positive number of seconds", c.Period)
+	}
+	if c.Workers < 0 {
+		return fmt.Errorf("invalid number of workers %d", c.Workers)
+	}
+	if err := c.Padding.Validate(); err != nil {
+		return err
+	}
+	switch c.Encoding {
+	case "", EncodingList:
+	case EncodingTruncated, EncodingBloom, EncodingCascade:
+		if c.EncryptMetadata {
+			return fmt.Errorf("encrypted status metadata requires the %q encoding", EncodingList)
+		}
+		if c.Encoding == EncodingBloom && !c.RevocationOnly {
+			return fmt.Errorf("the %q encoding cannot prove a valid status, set revocation only (--revocation-only) to use it", EncodingBloom)
+		}
+	default:
+		return fmt.Errorf("unknown encoding %q: use %q, %q, %q or %q", c.Encoding, EncodingList, EncodingTruncated, EncodingBloom, EncodingCascade)
+	}
+	if n := c.TruncateLength(); n < minTruncateBytes || n > 32 {
+		return fmt.Errorf("invalid truncated identifier length %d: must be between %d and 32 bytes", n, minTruncateBytes)
+	}
+	if err := validateFalsePositiveRate(c.FalsePositiveBound()); err != nil {
+		return err
+	}
+	if err := validateAlgorithm(c.SigningAlgorithm()); err != nil {
+		return err
+	}
+	switch c.KeyBackend {
+	case "", KeyBackendSoftware:
+	case KeyBackendPKCS11:
+		if err := c.PKCS11.Validate(); err != nil {
+			return err
+		}
+	default:
+		return fmt.Errorf("unknown key backend %q: use %q or %q", c.KeyBackend, KeyBackendSoftware, KeyBackendPKCS11)
+	}
+	switch c.SeedMode {
+	case "", SeedModeSecret, SeedModeArkg:
+	default:
+		return fmt.Errorf("unknown seed mode %q: use %q or %q", c.SeedMode, SeedModeSecret, SeedModeArkg)
+	}
+	switch c.Store {
+	case "", StoreFile, StoreBolt:
+	default:
+		return fmt.Errorf("unknown store %q: use %q or %q", c.Store, StoreFile, StoreBolt)
+	}
+	return nil
+}
+
+// SigningAlgorithm is the signature algorithm of new issuer keys
+func (c ListConfig) SigningAlgorithm() string {
+	if c.Algorithm == "" {
+		return defaultAlgorithm
+	}
+	return c.Algorithm
+}
+
+// TruncateLength is the identifier length of the truncated encoding in bytes
+func (c ListConfig) TruncateLength() int {
+	if c.TruncateBytes == 0 {
+		return defaultTruncateBytes
+	}
+	return c.TruncateBytes
+}
+
+// FalsePositiveBound is the false positive rate of the Bloom filter
+func (c ListConfig) FalsePositiveBound() float64 {
+	if c.FalsePositiveRate == 0 {
+		return defaultFalsePositiveRate
+	}
+	return c.FalsePositiveRate
+}
+
+// LoadListConfig loads the status list configuration or creates the default one
+func LoadListConfig(path string) (ListConfig, error) {
+	config := DefaultListConfig()
+	err := LoadJSON(&config, path)
+	if errors.Is(err, os.ErrNotExist) {
+		// Store the default configuration
+		return config, SaveJSON(config, path)
+	}
+	if err != nil {
+		return config, err
+	}
+	return config, config.Validate()
+}
+
+// UpdateConfig stores a new status list configuration and recomputes the list with it
+func (s *Server) UpdateConfig(config ListConfig) error {
+	if err := config.Validate(); err != nil {
+		return err
+	}
+
+	// New keys are generated in the new key backend, the token must open
+	backend := s.backend
+	if config.KeyBackend != s.Config.KeyBackend || config.PKCS11 != s.Config.PKCS11 {
+		var err error
+		if backend, err = OpenKeyBackend(config); err != nil {
+			return err
+		}
+	}
+	if err := SaveJSON(config, listConfigFile); err != nil {
+		return err
+	}
+	s.Config = config
+	s.clearPastWindows()
+	if backend != s.backend {
+		s.backend = backend
+		if err := s.reloadKeys(); err != nil {
+			return err
+		}
+	}
+
+	// Publish the list with the new configuration
+	return s.RecomputeDslJwt()
+}
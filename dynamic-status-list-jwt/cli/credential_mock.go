@@ -13,11 +13,11 @@ import (
 )
 
 const (
-	scheme    = "http"
-	host      = "localhost"
-	port      = "4321"
-	byteLen   = 16
-	statusURL = "http://localhost:PORT/sdb/1"
+	scheme       = "http"
+	host         = "localhost"
+	port         = "4321"
+	byteLen      = 16
+	statusListID = "1" // default status list identifier
 )
 
 // JWTData structure holds the JWT and associated metadata
@@ -28,7 +28,8 @@ type JWTData struct {
 }
 
 // IssueJWT generates a mock JWT with a unique ID (jti) and stores it at the specified path
-func (s *Server) IssueJWT(out string) ([]byte, *string) {
+// The JWT is bound to the holder's key (cnf), which is generated if the holder has none
+func (s *Server) IssueJWT(out string, holderKeyPath string) ([]byte, *string) {
 	// Generate a random JTI (JWT ID)
 	jtiByte := make([]byte, byteLen)
 	if _, err := rand.Read(jtiByte); err != nil {
@@ -37,14 +38,38 @@ func (s *Server) IssueJWT(out string) ([]byte, *string) {
 	}
 	jti := hex.EncodeToString(jtiByte)
 
+	// Load the holder's proof-of-possession key
+	holderKey, err := LoadOrCreateHolderKey(holderKeyPath)
+	if err != nil {
+		fmt.Printf("[ERROR] failed to load the holder key: %v\n", err)
+		return nil, nil
+	}
+	holderPK, err := holderKey.PublicKey()
+	if err != nil {
+		fmt.Printf("[ERROR] failed to load the holder key: %v\n", err)
+		return nil, nil
+	}
+	key, err := s.signingKey()
+	if err != nil {
+		fmt.Printf("[ERROR] failed to load the issuer key: %v\n", err)
+		return nil, nil
+	}
+	iss, err := s.issuer()
+	if err != nil {
+		fmt.Printf("[ERROR] failed to compute the issuer id: %v\n", err)
+		return nil, nil
+	}
+
 	// Create the JWT with claims
 	tok := jwt.New()
+	tok.Set(jwt.IssuerKey, iss)
 	tok.Set(jwt.SubjectKey, "Alice")
 	tok.Set(jwt.JwtIDKey, jti)
-	tok.Set("sdb", statusURL)
+	tok.Set("sdb", s.StatusURL())
+	tok.Set("cnf", map[string]interface{}{"jwk": holderPK})
 
 	// Sign the JWT
-	signedJWT, err := s.SignJWT(tok)
+	signedJWT, err := signJWTWith(tok, key)
 	if err != nil {
 		fmt.Printf("[ERROR] failed to sign JWT: %v\n", err)
 		return nil, nil
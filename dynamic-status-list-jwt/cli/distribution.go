@@ -0,0 +1,203 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+const (
+	pastWindowMaxAge = 24 * 60 * 60      // cache lifetime of past windows in seconds
+	pastWindowRange  = 30 * 24 * 60 * 60 // past windows are served for 30 days
+	maxPastWindows   = 1024              // past windows kept in memory
+	jwksMaxAge       = 5 * 60            // cache lifetime of the JWKS in seconds
+)
+
+// Signed past windows, valid while no event was logged and the signing key did not change
+type pastWindows struct {
+	events  int
+	kid     string
+	windows map[int64]DslJWT
+}
+
+// Serve runs the status list distribution point and recomputes the dSL every period
+func (s *Server) Serve(addr string, period time.Duration) error {
+	if period <= 0 {
+		return fmt.Errorf("invalid recompute period %s", period)
+	}
+
+	// Publish the first list before accepting requests
+	if err := s.RecomputeDslJwt(); err != nil {
+		return err
+	}
+
+	// Keep the list fresh in the background
+	go s.DslService(period)
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /sdb/{id}", s.handleStatusList)
+	mux.HandleFunc("GET /sdb/{id}/jwks", s.handleJWKS)
+
+	fmt.Printf("> Serving the DSL at %s://%s/sdb/%s\n", scheme, addr, s.Config.ID)
+	fmt.Printf("> Serving the issuer keys at %s://%s/sdb/%s/jwks\n", scheme, addr, s.Config.ID)
+	return http.ListenAndServe(addr, mux)
+}
+
+// StatusURL is the status list distribution point set in the sdb claim
+func (s *Server) StatusURL() string {
+	return scheme + "://" + host + ":" + port + "/sdb/" + s.Config.ID
+}
+
+// Serve the current signed dSL JWT
+func (s *Server) handleStatusList(w http.ResponseWriter, r *http.Request) {
+	// We support a single list in the open source release
+	if r.PathValue("id") != s.Config.ID {
+		http.NotFound(w, r)
+		return
+	}
+
+	// Verifiers can ask for a window either by timestamp (t) or by its nbf
+	nbf := DslWindowStart(time.Now().Unix(), s.Config.Period)
+	query := r.URL.Query()
+	switch {
+	case query.Has("nbf"):
+		var err error
+		nbf, err = strconv.ParseInt(query.Get("nbf"), 10, 64)
+		if err != nil {
+			http.Error(w, "invalid nbf", http.StatusBadRequest)
+			return
+		}
+	case query.Has("t"):
+		t, err := strconv.ParseInt(query.Get("t"), 10, 64)
+		if err != nil {
+			http.Error(w, "invalid timestamp", http.StatusBadRequest)
+			return
+		}
+		nbf = DslWindowStart(t, s.Config.Period)
+	}
+
+	dsl, ok := s.DslJwtByNbf(nbf)
+	if !ok {
+		// Past windows are recomputed from the status event log
+		var err error
+		dsl, ok, err = s.PastDslJwt(nbf)
+		if err != nil {
+			log.Println("[ERROR] failed to recompute a past window:", err)
+			http.Error(w, "failed to compute the status list", http.StatusInternalServerError)
+			return
+		}
+	}
+	if !ok {
+		http.Error(w, "status list window not available", http.StatusNotFound)
+		return
+	}
+
+	// Verifiers can cache the list until the window ends and revalidate it with the ETag
+	digest := sha256.Sum256([]byte(dsl.DslJwt))
+	etag := `"` + hex.EncodeToString(digest[:16]) + `"`
+	w.Header().Set("ETag", etag)
+	w.Header().Set("Cache-Control", fmt.Sprintf("public, max-age=%d", s.cacheMaxAge(dsl.Nbf)))
+	if r.Header.Get("If-None-Match") == etag {
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(dsl); err != nil {
+		log.Println("[ERROR] failed to write the status list:", err)
+	}
+}
+
+// JWKSURL is the URL of the issuer keys that verify the status list
+func (s *Server) JWKSURL() string {
+	return s.StatusURL() + "/jwks"
+}
+
+// Serve the published issuer keys
+// Verifiers refetch the JWKS to learn about rotated keys
+func (s *Server) handleJWKS(w http.ResponseWriter, r *http.Request) {
+	if r.PathValue("id") != s.Config.ID {
+		http.NotFound(w, r)
+		return
+	}
+	jwks, err := s.keyRing().JWKS(time.Now().Unix())
+	if err != nil {
+		log.Println("[ERROR] failed to build the JWKS:", err)
+		http.Error(w, "failed to build the JWKS", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Cache-Control", fmt.Sprintf("public, max-age=%d", jwksMaxAge))
+	w.Header().Set("Content-Type", "application/jwk-set+json")
+	if err := json.NewEncoder(w).Encode(jwks); err != nil {
+		log.Println("[ERROR] failed to write the JWKS:", err)
+	}
+}
+
+// Seconds a window can be cached
+// Past windows do not change, the current and next windows are cached until they end
+func (s *Server) cacheMaxAge(nbf int64) int64 {
+	now := time.Now().Unix()
+	if nbf+s.Config.Period <= now {
+		return pastWindowMaxAge
+	}
+	return nbf + s.Config.Period - now
+}
+
+// PastDslJwt signs the dSL of a past window with the statuses that were in force at that time
+// Windows older than pastWindowRange are not served, the signed windows are cached by nbf
+func (s *Server) PastDslJwt(nbf int64) (DslJWT, bool, error) {
+	period := s.Config.Period
+	now := time.Now().Unix()
+	if nbf%period != 0 || nbf >= DslWindowStart(now, period) || nbf < now-pastWindowRange {
+		return DslJWT{}, false, nil
+	}
+	// Readers share the state lock, so the event log is not read while it is appended to
+	unlock, err := LockFile(stateLockFile, false)
+	if err != nil {
+		return DslJWT{}, false, err
+	}
+	defer unlock()
+
+	// The entries are read with the events, the seed material of entries registered since the last publish is known
+	events, err := s.store.Events()
+	if err != nil {
+		return DslJWT{}, false, err
+	}
+	if err := s.NewDsl(); err != nil {
+		return DslJWT{}, false, err
+	}
+	key, err := s.signingKey()
+	if err != nil {
+		return DslJWT{}, false, err
+	}
+
+	// Past windows are signed one at a time
+	s.pastMu.Lock()
+	defer s.pastMu.Unlock()
+	if s.past.events != len(events) || s.past.kid != key.Kid || s.past.windows == nil || len(s.past.windows) >= maxPastWindows {
+		s.past = pastWindows{events: len(events), kid: key.Kid, windows: make(map[int64]DslJWT)}
+	}
+	if dsl, ok := s.past.windows[nbf]; ok {
+		return dsl, true, nil
+	}
+
+	signed, err := s.signDslJwt(nbf, events)
+	if err != nil {
+		return DslJWT{}, false, err
+	}
+	dsl := DslJWT{DslJwt: string(signed), Nbf: nbf}
+	s.past.windows[nbf] = dsl
+	return dsl, true, nil
+}
+
+// Forget the signed past windows, after a configuration change
+func (s *Server) clearPastWindows() {
+	s.pastMu.Lock()
+	defer s.pastMu.Unlock()
+	s.past = pastWindows{}
+}
@@ -1,7 +1,6 @@
 package main
 
 import (
-	"crypto"
 	"crypto/hmac"
 	"crypto/sha256"
 	"encoding/base64"
@@ -10,18 +9,22 @@ import (
 	"encoding/json"
 	"errors"
 	"fmt"
+	"log"
+	"maps"
 	"math"
 	"math/rand/v2"
 	"os"
+	"runtime"
+	"sync"
 	"time"
 
 	"github.com/lestrrat-go/jwx/v3/jwt"
 )
 
-const dSLPeriod = float64(60) // dSL time period in seconds
-
 // Generates revocation metadata and creates a revocation entry
-func (s *Server) NewDslEntry(in string, detached bool) error {
+// The private metadata is encrypted to the holder master key (masterKeyPath) or to the cnf key of the JWT,
+// it is only written in plaintext if allowPlaintext is set
+func (s *Server) NewDslEntry(in string, detached bool, masterKeyPath string, allowPlaintext bool) error {
 	// we can revoke an IDT that has status information
 	// or we can create a detached revocation token
 
@@ -52,32 +55,84 @@ func (s *Server) NewDslEntry(in string, detached bool) error {
 		return err
 	}
 
+	recipient, err := privateMetadataRecipient(idt, masterKeyPath)
+	if err != nil {
+		return err
+	}
+	if recipient == nil && !allowPlaintext {
+		return errors.New("the JWT is not bound to a holder key (cnf), the private metadata would not be encrypted: use --holder-master-key, or --allow-plaintext-metadata")
+	}
+
+	// Hold the state lock until the list is published, other dsl processes wait
+	unlock, err := LockFile(stateLockFile, true)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+	// Reload the map, another process may have changed it
+	err = s.NewDsl()
+	if err != nil {
+		return err
+	}
+
+	// An existing entry keeps its status, metadata and seed
+	entry, exists := (*s.dslSnapshot())[jti]
+	if !exists {
+		entry = DslEntry{Status: StatusValid, Created: time.Now().Unix(), SeedVersion: currentSeedVersion}
+		if s.Config.SeedMode == SeedModeArkg {
+			// The seed is bound to a key derived from the holder's key
+			if recipient == nil {
+				return errors.New("the ARKG seed mode requires a holder key: bind the JWT to a key (cnf) or use --holder-master-key")
+			}
+			entry.Arkg, err = newArkgSeed(recipient)
+			if err != nil {
+				return err
+			}
+			// The ECDH uses the active issuer key
+			key, err := s.signingKey()
+			if err != nil {
+				return err
+			}
+			entry.Arkg.Kid = key.Kid
+		}
+	}
+
 	// Create dsl private metadata as jwt
-	// Set: seed: base64url encoded random 16 byte value
-	jtiDigest := sha256.Sum256([]byte(jti))
-	seed := sha256.Sum256(append(s.Secret, jtiDigest[:]...))
-	seedHex := hex.EncodeToString(seed[:])
-
-	// Set the claims
-	dslPrivateMetadata := jwt.New()
-	dslPrivateMetadata.Set(jwt.SubjectKey, jti)
-	dslPrivateMetadata.Set("seed", seedHex)
+	dslPrivateMetadata, err := s.privateMetadata(jti, entry)
+	if err != nil {
+		return err
+	}
 	signedDslPM, err := s.SignJWT(dslPrivateMetadata)
-
-	// Add the jti to the list and set it to "valid"
-	(*s.Dsl)[jti] = true
 	if err != nil {
 		return err
 	}
 
+	// Encrypt the private metadata to the holder, the seed must not be readable from the file
+	if recipient != nil {
+		signedDslPM, err = encryptPrivateMetadata(signedDslPM, recipient)
+		if err != nil {
+			return err
+		}
+	} else {
+		fmt.Println("> Warning: the JWT is not bound to a holder key, the private metadata is not encrypted")
+	}
+
+	// Add the jti to the list and set it to "valid"
+	if !exists {
+		err = s.store.RecordEvent(StatusEvent{Time: entry.Created, Event: EventNew, Jti: jti, Status: entry.Status}, entry)
+		if err != nil {
+			return err
+		}
+		s.setDslEntry(jti, entry)
+	}
+
 	signedDetached := []byte{}
 	if detached {
 		t := jwt.New()
 		// Set the sub claim
 		t.Set(jwt.SubjectKey, jti)
-		// Set the dSL distribution point is /dcp/list identifier
-		// TODO: load this one via a variable
-		t.Set("sdb", "http://localhost:PORT/sdb/1")
+		// Set the dSL distribution point is /sdb/list identifier
+		t.Set("sdb", s.StatusURL())
 		signedDetached, err = s.SignJWT(t)
 		if err != nil {
 			return err
@@ -90,49 +145,88 @@ func (s *Server) NewDslEntry(in string, detached bool) error {
 		return err
 	}
 
-	// Save the DSL to a file
-	err = SaveDslMap("dsl-map.json", *s.Dsl)
-	if err != nil {
-		return err
-	}
 	// Recompute
-	return s.RecomputeDslJwt()
+	return s.publishDslJwtAt(time.Now().Unix())
 }
 
-// Create or load a new Dsl
-func (s *Server) NewDsl(filename string) error {
-	// We need a key-value map, key: jti, value: valid/invalid
-	// Note: additional status metadata can be added (encrypted) -> for the v2 of the open source release
-	// Note: we support a single map in the open source release
+// Private status metadata of an entry
+// secret mode: the seed, ARKG mode: the key handle and the issuer key the holder derives the seed with
+func (s *Server) privateMetadata(jti string, entry DslEntry) (jwt.Token, error) {
+	t := jwt.New()
+	t.Set(jwt.SubjectKey, jti)
+	if entry.Arkg == nil {
+		secret, err := s.entrySeed(jti, entry)
+		if err != nil {
+			return nil, err
+		}
+		t.Set("seed", hex.EncodeToString(secret.seed[:]))
+		return t, nil
+	}
 
-	// Try to load the dsl
-	dslMap, err := LoadDslMap(filename)
-	if err == nil {
-		// map loaded, exit
-		s.Dsl = &dslMap
-		return nil
+	key, err := s.arkgIssuerKey(entry.Arkg.Kid)
+	if err != nil {
+		return nil, err
+	}
+	ipk, err := key.ecdhPublicKey()
+	if err != nil {
+		return nil, err
 	}
+	t.Set("arkg", map[string]string{
+		"kh":  entry.Arkg.Handle,
+		"ipk": base64.RawURLEncoding.EncodeToString(ipk.Bytes()),
+	})
+	return t, nil
+}
 
-	// Create a new empty map
-	dslMap = make(map[string]bool)
-	err = SaveDslMap(filename, dslMap)
+// Load the Dsl from the store
+func (s *Server) NewDsl() error {
+	// We need a key-value map, key: jti, value: status entry (status and revocation metadata)
+	// Note: we support a single map in the open source release
+	dslMap, err := s.store.LoadEntries()
 	if err != nil {
 		return err
 	}
 
+	s.mu.Lock()
 	s.Dsl = &dslMap
+	s.mu.Unlock()
 
+	// Evict the seeds of entries that are gone or have another seed version
+	s.seeds.Range(func(k, _ any) bool {
+		key := k.(seedCacheKey)
+		if entry, ok := dslMap[key.jti]; !ok || entry.seedVersion() != key.version {
+			s.seeds.Delete(key)
+		}
+		return true
+	})
 	return nil
 }
 
+// Current dsl map
+// The map is never modified in place (copy-on-write), so readers can use it without locking
+func (s *Server) dslSnapshot() *map[string]DslEntry {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.Dsl
+}
+
+// Set a single entry on a copy of the dsl map
+func (s *Server) setDslEntry(jti string, entry DslEntry) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	dslMap := maps.Clone(*s.Dsl)
+	dslMap[jti] = entry
+	s.Dsl = &dslMap
+}
+
 // Save the dsl map to a file
-func SaveDslMap(filename string, m map[string]bool) error {
+func SaveDslMap(filename string, m map[string]DslEntry) error {
 	jsonData, err := json.MarshalIndent(m, "", "  ")
 	if err != nil {
 		return fmt.Errorf("failed to marshal JSON: %w", err)
 	}
 
-	err = os.WriteFile(filename, jsonData, 0600)
+	err = WriteFileAtomic(filename, jsonData, 0600)
 	if err != nil {
 		return fmt.Errorf("failed to write file: %w", err)
 	}
@@ -144,8 +238,11 @@ type DslJWT struct {
 	Nbf    int64  `json:"nbf"`
 }
 
+// File with the previous, current and next dSL windows
+const dslWindowsFile = "dsl-windows.json"
+
 // Recomputes the dSL every period
-// Note: In production, three states should always be available: now-period, now, now+period
+// Three windows are always available: now-period, now and now+period
 func (s *Server) DslService(period time.Duration) {
 	// Create a new ticker with the specified period
 	ticker := time.NewTicker(period)
@@ -155,7 +252,9 @@ func (s *Server) DslService(period time.Duration) {
 	for {
 		select {
 		case <-ticker.C: // The ticker sends a message every period
-			s.RecomputeDslJwt() // Call your function
+			if err := s.RecomputeDslJwt(); err != nil {
+				log.Println("[ERROR] failed to recompute the DSL:", err)
+			}
 		}
 	}
 }
@@ -168,124 +267,444 @@ func (s *Server) RecomputeDslJwt() error {
 	return s.RecomputeDslJwtAt(tNow)
 }
 
-// Save the dsl as JWT
+// Save the dsl windows around tNow as JWTs
+// dsl.json holds the current window, dsl-windows.json holds the previous, current and next window
 func (s *Server) RecomputeDslJwtAt(tNow int64) error {
+	unlock, err := LockFile(stateLockFile, true)
+	if err != nil {
+		return err
+	}
+	defer unlock()
 
-	// Get the current time
-	tNext := tNow + int64(dSLPeriod)
+	// Pick up the changes made by other dsl processes
+	err = s.NewDsl()
+	if err != nil {
+		return err
+	}
+	err = s.reloadKeys()
+	if err != nil {
+		return err
+	}
+	return s.publishDslJwtAt(tNow)
+}
 
-	// Compute the revocation identifiers
-	sid := s.ComputeRevocationIdentifiers(s.Dsl, tNow)
+// Compute, sign and save the dsl windows around tNow, and the issuer keys that verify them
+// The caller holds the state lock
+func (s *Server) publishDslJwtAt(tNow int64) error {
 
-	t := jwt.New()
-	t.Set("typ", "dsl/v1")
-	jwkThumbprint, err := s.PublicKey.Thumbprint(crypto.SHA256)
+	// Start of the window that contains tNow
+	period := s.Config.Period
+	nbf := DslWindowStart(tNow, period)
+
+	events, err := s.store.Events()
 	if err != nil {
 		return err
 	}
-	t.Set("iss", hex.EncodeToString(jwkThumbprint))
-	t.Set(jwt.NotBeforeKey, tNow)
-	t.Set(jwt.ExpirationKey, tNext-1)
-	t.Set("nxt", tNext)
-	t.Set("sid", sid) // revoked identifiers
+	windows := make([]DslJWT, 0, 3)
+	for _, start := range []int64{nbf - period, nbf, nbf + period} {
+		signed, err := s.signDslJwt(start, events)
+		if err != nil {
+			return err
+		}
+		windows = append(windows, DslJWT{DslJwt: string(signed), Nbf: start})
+	}
 
-	// Sign the jwt
-	signed, err := s.SignJWT(t)
+	s.mu.Lock()
+	s.DslJwt = []byte(windows[1].DslJwt)
+	s.DslWindows = windows
+	s.mu.Unlock()
+
+	// Save the JWTs
+	err = SaveJSON(windows[1], "dsl.json")
+	if err != nil {
+		return err
+	}
+	err = SaveJSON(windows, dslWindowsFile)
 	if err != nil {
 		return err
 	}
-	s.DslJwt = signed
 
-	// Save the JWT
-	return SaveJSON(DslJWT{DslJwt: string(signed), Nbf: tNow}, "dsl.json")
+	// Save the JWKS
+	jwks, err := s.keyRing().JWKS(time.Now().Unix())
+	if err != nil {
+		return err
+	}
+	return SaveJSON(jwks, jwksFile)
 }
 
-// Compute the revocation identifiers
-func (s *Server) ComputeRevocationIdentifiers(m *map[string]bool, tNow int64) []string {
+// Sign the dsl for the window starting at nbf
+// The window reflects the statuses in force at its end (or now, for the current and future windows)
+func (s *Server) signDslJwt(nbf int64, events []StatusEvent) ([]byte, error) {
 
-	// We store the results into the revocation list
-	// Note: more space-efficient methods can be used, such as Bloom filter, CRLite, etc.
-	revocationList := []string{}
+	tNext := nbf + s.Config.Period
+
+	// Get the statuses in force for the window
+	dsl := s.DslAt(min(tNext-1, time.Now().Unix()), events)
+
+	// Compute the revocation identifiers
+	ids, err := s.ComputeRevocationIdentifiers(dsl, nbf)
+	if err != nil {
+		return nil, err
+	}
 
-	// Loop over the revocation statuses and compute the identifiers
-	for jti, valid := range *m {
+	// The iss claim identifies the issuer, the kid header the signing key
+	iss, err := s.issuer()
+	if err != nil {
+		return nil, err
+	}
 
-		jtiDigest := sha256.Sum256([]byte(jti))
+	t := jwt.New()
+	t.Set("typ", "dsl/v1")
+	t.Set("iss", iss)
+	t.Set(jwt.NotBeforeKey, nbf)
+	t.Set(jwt.ExpirationKey, tNext-1)
+	t.Set("nxt", tNext)
+	t.Set("prd", s.Config.Period) // dSL period in seconds
+	// Status identifiers: sid (list) or sfl (compact encodings)
+	err = setStatusClaims(t, ids, s.Config)
+	if err != nil {
+		return nil, err
+	}
+	if len(ids.Metadata) > 0 {
+		t.Set("sme", ids.Metadata) // encrypted status metadata, keyed by sid
+	}
+	if s.Config.Padding.Enabled() {
+		t.Set("pad", s.Config.Padding.Claim()) // padding policy, sid contains decoys
+	}
+
+	// Sign the jwt
+	return s.SignJWT(t)
+}
+
+// Start of the dSL window that contains t
+func DslWindowStart(t int64, period int64) int64 {
+	return int64(math.Floor(float64(t)/float64(period))) * period
+}
+
+// Period of a dSL JWT in seconds
+// Lists without the prd claim were computed with the default period
+func DslPeriod(t jwt.Token) (int64, error) {
+	if !t.Has("prd") {
+		return defaultDslPeriod, nil
+	}
+	var prd float64
+	if err := t.Get("prd", &prd); err != nil {
+		return 0, err
+	}
+	if prd <= 0 {
+		return 0, fmt.Errorf("invalid dSL period %v", prd)
+	}
+	return int64(prd), nil
+}
+
+// Per-entry secrets, cached across recomputations
+type entrySecret struct {
+	seed      [32]byte // seed of the entry's seed version, or the ARKG seed
+	jtiDigest [32]byte // H(jti)
+}
+
+// Seeds are cached per jti and seed version
+type seedCacheKey struct {
+	jti     string
+	version int
+}
+
+// Seed of an entry, computed once per jti
+func (s *Server) entrySeed(jti string, entry DslEntry) (*entrySecret, error) {
+	key := seedCacheKey{jti: jti, version: entry.seedVersion()}
+	if cached, ok := s.seeds.Load(key); ok {
+		return cached.(*entrySecret), nil
+	}
+	e := &entrySecret{jtiDigest: sha256.Sum256([]byte(jti))}
+	var err error
+	switch {
+	case entry.Arkg != nil:
+		e.seed, err = s.arkgEntrySeed(entry.Arkg, e.jtiDigest)
+	case key.version == SeedVersionLegacy && s.Secret == nil:
+		err = ErrLegacySecretMissing
+	case key.version == SeedVersionLegacy:
 		// Note: we selected this function for efficiency purposes; other seed derivation approaches can be used
-		seed := sha256.Sum256(append(s.Secret, jtiDigest[:]...))
+		h256 := sha256.New()
+		h256.Write(s.Secret)
+		h256.Write(e.jtiDigest[:])
+		h256.Sum(e.seed[:0])
+	case key.version == SeedVersionHKDF && s.seedKey == nil:
+		err = ErrSeedMasterMissing
+	case key.version == SeedVersionHKDF:
+		e.seed, err = hkdfSeed(s.seedKey, e.jtiDigest)
+	default:
+		err = fmt.Errorf("unknown seed version %d", key.version)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("jti %s: %w", jti, err)
+	}
+	s.seeds.Store(key, e)
+	return e, nil
+}
+
+// Number of recomputation workers
+func (s *Server) workers() int {
+	if s.Config.Workers > 0 {
+		return s.Config.Workers
+	}
+	return runtime.GOMAXPROCS(0)
+}
+
+// Compute the revocation identifiers
+// If enabled, the status metadata of each entry is encrypted for the holder (sid -> blob)
+// The entries are split across a pool of workers, each writes its own part of the preallocated output
+func (s *Server) ComputeRevocationIdentifiers(m *map[string]DslEntry, tNow int64) (DslIdentifiers, error) {
+
+	jtis := make([]string, 0, len(*m))
+	for jti := range *m {
+		jtis = append(jtis, jti)
+	}
+	n := len(jtis)
 
-		// Compute the revocation entry
-		reB64 := ComputeRevocationIdentifier(jti, seed[:], tNow, valid)
+	// We store the results into the revocation list
+	// Note: compact encodings (truncated, Bloom filter, filter cascade) are applied when the list is signed
+	ids := DslIdentifiers{
+		Sid:      make([]string, n, s.Config.Padding.PaddedSize(n)),
+		Metadata: map[string]string{},
+	}
+	cascade := s.Config.Encoding == EncodingCascade
+	if cascade {
+		// Every entry has an identifier for each of the other statuses
+		ids.Excluded = make([][]byte, n*(len(entryStatuses)-1))
+	}
+	var blobs []string
+	if s.Config.EncryptMetadata {
+		blobs = make([]string, n)
+	}
 
-		revocationList = append(revocationList, reB64)
+	workers := min(s.workers(), max(n, 1))
+	chunk := (n + workers - 1) / workers
+	errs := make([]error, workers)
+	var wg sync.WaitGroup
+	for w := range workers {
+		wg.Add(1)
+		go func(w int) {
+			defer wg.Done()
+			for i := w * chunk; i < min((w+1)*chunk, n); i++ {
+				jti := jtis[i]
+				entry := (*m)[jti]
+				secret, err := s.entrySeed(jti, entry)
+				if err != nil {
+					errs[w] = err
+					return
+				}
+
+				// Compute the revocation entry
+				token, err := NewToken(secret.seed[:], tNow, s.Config.Period)
+				if err != nil {
+					errs[w] = err
+					return
+				}
+				ids.Sid[i] = base64.RawURLEncoding.EncodeToString(statusIdentifierOf(secret.jtiDigest, token, entry.Status))
+
+				// The filter cascade must reject the identifiers of the other statuses
+				if cascade {
+					k := i * (len(entryStatuses) - 1)
+					for _, status := range entryStatuses {
+						if status != entry.Status {
+							ids.Excluded[k] = statusIdentifierOf(secret.jtiDigest, token, status)
+							k++
+						}
+					}
+				}
+
+				// Encrypt the status metadata under a key derived from the token
+				if blobs != nil {
+					blobs[i], err = EncryptStatusInfo(token, jti, ids.Sid[i], NewStatusInfo(entry))
+					if err != nil {
+						errs[w] = err
+						return
+					}
+				}
+			}
+		}(w)
+	}
+	wg.Wait()
+	if err := errors.Join(errs...); err != nil {
+		return DslIdentifiers{}, err
+	}
+	for i, blob := range blobs {
+		ids.Metadata[ids.Sid[i]] = blob
+	}
 
+	// Hide the number of entries with decoys
+	var err error
+	ids.Sid, err = padIdentifiers(ids.Sid, ids.Metadata, s.Config.Padding, s.Config.EncryptMetadata)
+	if err != nil {
+		return DslIdentifiers{}, err
 	}
+
 	// Shuffle the elements
-	rand.Shuffle(len(revocationList), func(i, j int) {
-		revocationList[i], revocationList[j] = revocationList[j], revocationList[i]
+	rand.Shuffle(len(ids.Sid), func(i, j int) {
+		ids.Sid[i], ids.Sid[j] = ids.Sid[j], ids.Sid[i]
 	})
 
-	return revocationList
+	return ids, nil
 }
 
-func LoadDslMap(filename string) (map[string]bool, error) {
+// Load the dsl map from a file
+// Maps in the legacy boolean format are migrated to status entries
+func LoadDslMap(filename string) (map[string]DslEntry, error) {
 	data, err := os.ReadFile(filename)
 	if err != nil {
 		return nil, fmt.Errorf("failed to read file: %w", err)
 	}
 
-	var myMap map[string]bool
-	if err := json.Unmarshal(data, &myMap); err != nil {
+	var rawMap map[string]json.RawMessage
+	if err := json.Unmarshal(data, &rawMap); err != nil {
 		return nil, fmt.Errorf("failed to unmarshal JSON: %w", err)
 	}
 
+	myMap := make(map[string]DslEntry, len(rawMap))
+	migrated := false
+	for jti, raw := range rawMap {
+		entry, legacy, err := decodeDslEntry(raw)
+		if err != nil {
+			return nil, fmt.Errorf("invalid entry for jti %s: %w", jti, err)
+		}
+		migrated = migrated || legacy
+		myMap[jti] = entry
+	}
+
+	// Store the migrated map
+	if migrated {
+		fmt.Printf("> Migrating %s to status entries\n", filename)
+		if err := SaveDslMap(filename, myMap); err != nil {
+			return nil, err
+		}
+	}
+
 	return myMap, nil
 }
 
 // Revoke a credential
-func (s *Server) Revoke(jti string) error {
-	_, ok := (*s.Dsl)[jti]
+// Revocation is final: revoked credentials cannot be reinstated
+func (s *Server) Revoke(jti string, meta StatusMetadata) error {
+	return s.SetStatus(jti, StatusRevoked, meta)
+}
+
+// Suspend a valid credential until it is reinstated
+func (s *Server) Suspend(jti string, meta StatusMetadata) error {
+	return s.SetStatus(jti, StatusSuspended, meta)
+}
+
+// Reinstate a suspended credential
+func (s *Server) Reinstate(jti string, meta StatusMetadata) error {
+	return s.SetStatus(jti, StatusValid, meta)
+}
+
+// SetStatus changes the status of a credential and recomputes the DSL
+func (s *Server) SetStatus(jti string, status EntryStatus, meta StatusMetadata) error {
+	unlock, err := LockFile(stateLockFile, true)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	// Reload the map, another process may have changed it
+	err = s.NewDsl()
+	if err != nil {
+		return err
+	}
+
+	entry, ok := (*s.dslSnapshot())[jti]
 	// If the key exists
 	if !ok {
 		return errors.New("jti not found. Create a new entry, first using the 'new' command")
 	}
-	// Update the state
-	(*s.Dsl)[jti] = false
+	if err := entry.CanChangeTo(status); err != nil {
+		return err
+	}
+	now := time.Now().Unix()
+	if meta.Time == 0 {
+		meta.Time = now
+	}
+
+	// The change cannot take effect before the credential's history or in the future
+	if meta.Time > now {
+		return fmt.Errorf("the status change time %d is in the future", meta.Time)
+	}
+	if meta.Time < entry.Created {
+		return fmt.Errorf("the status change time %d is before the entry was created (%d)", meta.Time, entry.Created)
+	}
+	events, err := s.store.Events()
+	if err != nil {
+		return err
+	}
+	for _, ev := range events {
+		if ev.Jti == jti && meta.Time < ev.Time {
+			return fmt.Errorf("the status change time %d is before the last status change of the entry (%d)", meta.Time, ev.Time)
+		}
+	}
+
+	// Record the status change and update the state
+	entry.Status = status
+	entry.Metadata = &meta
+	err = s.store.RecordEvent(StatusEvent{Time: meta.Time, Event: statusEventName(status), Jti: jti, Status: status, Metadata: &meta}, entry)
+	if err != nil {
+		return err
+	}
+	s.setDslEntry(jti, entry)
 
 	// Recompute the DSL
-	return s.RecomputeDslJwt()
+	return s.publishDslJwtAt(time.Now().Unix())
 }
 
-func ComputeRevocationIdentifier(jti string, seed []byte, tNow int64, valid bool) string {
-
-	jtiDigest := sha256.Sum256([]byte(jti))
-	// Note: we selected this function for efficiency purposes; other seed derivation approaches can be used
+func ComputeRevocationIdentifier(jti string, seed []byte, tNow int64, period int64, status EntryStatus) string {
 
-	token, err := NewToken(seed, tNow)
+	token, err := NewToken(seed, tNow, period)
 	if err != nil {
 		return ""
 	}
 
+	return base64.RawURLEncoding.EncodeToString(statusIdentifier(jti, token, status))
+}
+
+// Suffix of the suspended identifier
+const suspendedLabel = "suspended"
+
+// Compute the status identifier of a token
+//
+//	valid     = H(token, jti_digest)
+//	revoked   = H(H(token, jti_digest))
+//	suspended = H(H(token, jti_digest), "suspended")
+func statusIdentifier(jti string, token []byte, status EntryStatus) []byte {
+	return statusIdentifierOf(sha256.Sum256([]byte(jti)), token, status)
+}
+
+// Compute the status identifier from the jti digest
+func statusIdentifierOf(jtiDigest [32]byte, token []byte, status EntryStatus) []byte {
 	// valid = H(token, s_id)
 	h256 := sha256.New()
-	data := append(token, jtiDigest[:]...) // Store appended data properly
-	h256.Write(data)
+	h256.Write(token)
+	h256.Write(jtiDigest[:])
 	revocationEntry := h256.Sum(nil)
-	if !valid {
+
+	switch status {
+	case StatusRevoked:
 		// token is revoked
 		h256 = sha256.New()
 		h256.Write(revocationEntry)
 		revocationEntry = h256.Sum(nil)
+	case StatusSuspended:
+		// token is suspended
+		h256 = sha256.New()
+		h256.Write(revocationEntry)
+		h256.Write([]byte(suspendedLabel))
+		revocationEntry = h256.Sum(nil)
 	}
-	//
-	reB64 := base64.RawURLEncoding.EncodeToString(revocationEntry)
-	return reB64
+	return revocationEntry
 }
 
-func NewToken(seed []byte, tNow int64) ([]byte, error) {
+func NewToken(seed []byte, tNow int64, period int64) ([]byte, error) {
 
 	// t' = floor(t_now / period)
-	t := uint64(math.Floor(float64(tNow) / dSLPeriod))
+	t := uint64(math.Floor(float64(tNow) / float64(period)))
 
 	// token = HMAC(seed, t’)
 	tBytes := make([]byte, 8) // uint64 needs 8 bytes
@@ -301,28 +720,12 @@ func NewToken(seed []byte, tNow int64) ([]byte, error) {
 
 }
 
-func ComputeRevocationIdentifierWithToken(jti string, tokenB64 string, valid bool) (string, error) {
-
-	jtiDigest := sha256.Sum256([]byte(jti))
-	// Note: we selected this function for efficiency purposes; other seed derivation approaches can be used
+func ComputeRevocationIdentifierWithToken(jti string, tokenB64 string, status EntryStatus) (string, error) {
 
 	token, err := base64.RawURLEncoding.DecodeString(tokenB64)
 	if err != nil {
 		return "", err
 	}
 
-	// valid = H(token, s_id)
-	h256 := sha256.New()
-	data := append(token, jtiDigest[:]...) // Store appended data properly
-	h256.Write(data)
-	revocationEntry := h256.Sum(nil)
-	if !valid {
-		// token is revoked
-		h256 = sha256.New()
-		h256.Write(revocationEntry)
-		revocationEntry = h256.Sum(nil)
-	}
-	//
-	reB64 := base64.RawURLEncoding.EncodeToString(revocationEntry)
-	return reB64, nil
+	return base64.RawURLEncoding.EncodeToString(statusIdentifier(jti, token, status)), nil
 }
@@ -0,0 +1,65 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"testing"
+	"time"
+)
+
+// Server with n synthetic entries kept in memory, every 10th entry is revoked
+func benchServer(b *testing.B, n int) (*Server, map[string]DslEntry) {
+	b.Helper()
+	master := make([]byte, seedMasterSize)
+	if _, err := rand.Read(master); err != nil {
+		b.Fatal(err)
+	}
+	s := &Server{seedKey: seedKeyOf(master), Config: DefaultListConfig()}
+
+	dsl := make(map[string]DslEntry, n)
+	jti := make([]byte, byteLen)
+	for i := range n {
+		if _, err := rand.Read(jti); err != nil {
+			b.Fatal(err)
+		}
+		entry := DslEntry{Status: StatusValid, SeedVersion: currentSeedVersion}
+		if i%10 == 0 {
+			entry.Status = StatusRevoked
+		}
+		dsl[hex.EncodeToString(jti)] = entry
+	}
+	return s, dsl
+}
+
+func BenchmarkComputeRevocationIdentifiers(b *testing.B) {
+	for _, n := range []int{10000, 100000} {
+		s, dsl := benchServer(b, n)
+		nbf := DslWindowStart(time.Now().Unix(), s.Config.Period)
+
+		// Every window derives the seeds again
+		b.Run(fmt.Sprintf("entries=%d/cold", n), func(b *testing.B) {
+			for range b.N {
+				b.StopTimer()
+				s.seeds.Clear()
+				b.StartTimer()
+				if _, err := s.ComputeRevocationIdentifiers(&dsl, nbf); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+
+		// A running server derives the seeds once
+		b.Run(fmt.Sprintf("entries=%d/cached", n), func(b *testing.B) {
+			if _, err := s.ComputeRevocationIdentifiers(&dsl, nbf); err != nil {
+				b.Fatal(err)
+			}
+			b.ResetTimer()
+			for i := range b.N {
+				if _, err := s.ComputeRevocationIdentifiers(&dsl, nbf+int64(i)*s.Config.Period); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
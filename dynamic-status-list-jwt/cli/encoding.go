@@ -0,0 +1,255 @@
+package main
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"slices"
+	"sort"
+
+	"github.com/lestrrat-go/jwx/v3/jwt"
+)
+
+// Encodings of the status identifiers
+const (
+	EncodingList      = "list"      // sid claim: array of base64url identifiers
+	EncodingTruncated = "truncated" // sfl claim: sorted set of truncated identifiers
+	EncodingBloom     = "bloom"     // sfl claim: Bloom filter, with false positives, proves revoked and suspended only
+	EncodingCascade   = "cascade"   // sfl claim: filter cascade, exact for the identifiers of the issued tokens
+)
+
+const (
+	defaultTruncateBytes     = 16    // truncated identifier length in bytes
+	minTruncateBytes         = 16    // shorter prefixes let a holder find a matching token by trial
+	defaultFalsePositiveRate = 0.001 // false positive rate of the Bloom filter
+
+	// False positive rate of the first level of a filter cascade
+	// A holder who picks tokens needs about 2^32 tries to match an identifier the issuer did not compute
+	cascadeFalsePositiveRate = 0x1p-32
+	// Highest estimated false positive rate of the first cascade level a verifier accepts
+	maxCascadeFalsePositiveRate = 0x1p-28
+)
+
+var (
+	// ErrDslAmbiguous is returned when the proof matches more than one status
+	// This can only happen with the false positives of a Bloom filter
+	ErrDslAmbiguous = errors.New("status identifier matches more than one status")
+	// ErrDslNotProven is returned when a Bloom filter matches the valid identifier
+	// A holder can try tokens until one is a false positive, so the filter only proves a suspended or revoked status
+	ErrDslNotProven = errors.New("the status list encoding cannot prove a valid status")
+)
+
+// StatusFilter is the sfl claim of a compact dSL
+type StatusFilter struct {
+	Encoding string         `json:"enc"`
+	Length   int            `json:"len,omitempty"`    // truncated: identifier length in bytes
+	Data     string         `json:"data,omitempty"`   // truncated: base64url encoded sorted identifiers
+	Levels   []*BloomFilter `json:"levels,omitempty"` // bloom: a single filter, cascade: the filter levels
+}
+
+// DslIdentifiers are the identifiers computed for a dSL window
+type DslIdentifiers struct {
+	Sid      []string          // published identifiers, including decoys
+	Excluded [][]byte          // identifiers of the statuses the entries are not in (filter cascade only)
+	Metadata map[string]string // encrypted status metadata, keyed by sid
+}
+
+// Set the identifiers in the configured encoding
+func setStatusClaims(t jwt.Token, ids DslIdentifiers, config ListConfig) error {
+	if config.Encoding == "" || config.Encoding == EncodingList {
+		t.Set("sid", ids.Sid) // status identifiers
+		return nil
+	}
+
+	raw := make([][]byte, len(ids.Sid))
+	for i, sid := range ids.Sid {
+		id, err := base64.RawURLEncoding.DecodeString(sid)
+		if err != nil {
+			return err
+		}
+		raw[i] = id
+	}
+
+	filter := StatusFilter{Encoding: config.Encoding}
+	switch config.Encoding {
+	case EncodingTruncated:
+		filter.Length = config.TruncateLength()
+		prefixes := make([][]byte, len(raw))
+		for i, id := range raw {
+			prefixes[i] = id[:filter.Length]
+		}
+		slices.SortFunc(prefixes, bytes.Compare)
+		filter.Data = base64.RawURLEncoding.EncodeToString(bytes.Join(prefixes, nil))
+	case EncodingBloom:
+		filter.Levels = []*BloomFilter{NewBloomFilter(raw, config.FalsePositiveBound(), 0)}
+	case EncodingCascade:
+		levels, err := NewFilterCascade(raw, ids.Excluded)
+		if err != nil {
+			return err
+		}
+		filter.Levels = levels
+	default:
+		return fmt.Errorf("unknown encoding %q", config.Encoding)
+	}
+	t.Set("sfl", filter) // status filter
+	return nil
+}
+
+// StatusSet is a decoded set of status identifiers
+type StatusSet interface {
+	// Contains reports whether the base64url encoded identifier is in the set
+	Contains(sid string) bool
+	// Approximate reports whether the set has false positives for identifiers the issuer did not compute
+	Approximate() bool
+}
+
+// StatusSetOf decodes the status identifiers of a dSL JWT in any encoding
+func StatusSetOf(t jwt.Token) (StatusSet, error) {
+	if !t.Has("sfl") {
+		var sid []interface{}
+		if err := t.Get("sid", &sid); err != nil {
+			return nil, fmt.Errorf("%w: %w", ErrDslMalformed, err)
+		}
+		set := make(sidSet, len(sid))
+		for _, v := range sid {
+			str, ok := v.(string)
+			if !ok {
+				return nil, fmt.Errorf("%w: sid contains a non-string value", ErrDslMalformed)
+			}
+			set[str] = struct{}{}
+		}
+		return set, nil
+	}
+
+	var raw map[string]interface{}
+	if err := t.Get("sfl", &raw); err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrDslMalformed, err)
+	}
+	data, err := json.Marshal(raw)
+	if err != nil {
+		return nil, err
+	}
+	var filter StatusFilter
+	if err := json.Unmarshal(data, &filter); err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrDslMalformed, err)
+	}
+
+	switch filter.Encoding {
+	case EncodingTruncated:
+		ids, err := base64.RawURLEncoding.DecodeString(filter.Data)
+		if err != nil || filter.Length <= 0 || len(ids)%filter.Length != 0 {
+			return nil, fmt.Errorf("%w: invalid truncated identifiers", ErrDslMalformed)
+		}
+		if filter.Length < minTruncateBytes {
+			return nil, fmt.Errorf("%w: identifiers truncated to %d bytes, at least %d are required", ErrDslMalformed, filter.Length, minTruncateBytes)
+		}
+		return truncatedSet{length: filter.Length, ids: ids}, nil
+	case EncodingBloom, EncodingCascade:
+		if len(filter.Levels) == 0 || filter.Encoding == EncodingBloom && len(filter.Levels) != 1 {
+			return nil, fmt.Errorf("%w: invalid number of filter levels", ErrDslMalformed)
+		}
+		for _, f := range filter.Levels {
+			if err := f.decode(); err != nil {
+				return nil, fmt.Errorf("%w: %w", ErrDslMalformed, err)
+			}
+		}
+		if filter.Encoding == EncodingBloom {
+			return bloomSet{filter.Levels[0]}, nil
+		}
+		// Forged tokens only match the first level with its false positive rate
+		if rate := filter.Levels[0].FalsePositiveRate(); rate > maxCascadeFalsePositiveRate {
+			return nil, fmt.Errorf("%w: the first cascade level has a false positive rate of %.2g, at most %.2g is accepted", ErrDslMalformed, rate, maxCascadeFalsePositiveRate)
+		}
+		return cascadeSet(filter.Levels), nil
+	}
+	return nil, fmt.Errorf("%w: unknown encoding %q", ErrDslMalformed, filter.Encoding)
+}
+
+// Plain list of identifiers
+type sidSet map[string]struct{}
+
+func (s sidSet) Contains(sid string) bool {
+	_, ok := s[sid]
+	return ok
+}
+
+func (s sidSet) Approximate() bool { return false }
+
+// Sorted truncated identifiers, looked up with a binary search
+type truncatedSet struct {
+	length int
+	ids    []byte
+}
+
+func (s truncatedSet) Contains(sid string) bool {
+	id, err := base64.RawURLEncoding.DecodeString(sid)
+	if err != nil || len(id) < s.length {
+		return false
+	}
+	prefix := id[:s.length]
+	n := len(s.ids) / s.length
+	i := sort.Search(n, func(i int) bool {
+		return bytes.Compare(s.ids[i*s.length:(i+1)*s.length], prefix) >= 0
+	})
+	return i < n && bytes.Equal(s.ids[i*s.length:(i+1)*s.length], prefix)
+}
+
+func (s truncatedSet) Approximate() bool { return false }
+
+type bloomSet struct{ filter *BloomFilter }
+
+func (s bloomSet) Contains(sid string) bool {
+	id, err := base64.RawURLEncoding.DecodeString(sid)
+	if err != nil {
+		return false
+	}
+	return s.filter.Contains(id, 0)
+}
+
+func (s bloomSet) Approximate() bool { return true }
+
+type cascadeSet []*BloomFilter
+
+func (s cascadeSet) Contains(sid string) bool {
+	id, err := base64.RawURLEncoding.DecodeString(sid)
+	if err != nil {
+		return false
+	}
+	return cascadeContains(s, id)
+}
+
+func (s cascadeSet) Approximate() bool { return false }
+
+// LookupStatus returns the status whose identifier is in the set, and the identifier
+// A valid status is only returned by exact sets
+func LookupStatus(set StatusSet, jti string, token string) (EntryStatus, string, error) {
+	var (
+		status EntryStatus
+		sid    string
+		found  int
+	)
+	// Check the identifier of every status: valid, suspended and revoked
+	for _, candidateStatus := range entryStatuses {
+		candidate, err := ComputeRevocationIdentifierWithToken(jti, token, candidateStatus)
+		if err != nil {
+			return "", "", err
+		}
+		if set.Contains(candidate) {
+			status, sid = candidateStatus, candidate
+			found++
+		}
+	}
+
+	switch found {
+	case 0:
+		return "", "", errors.New("status list id not found")
+	case 1:
+		if status == StatusValid && set.Approximate() {
+			return "", "", ErrDslNotProven
+		}
+		return status, sid, nil
+	}
+	return "", "", ErrDslAmbiguous
+}
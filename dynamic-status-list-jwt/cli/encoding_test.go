@@ -0,0 +1,117 @@
+package main
+
+import (
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"math"
+	"testing"
+)
+
+// Issue a credential under each encoding, and verify it as valid and then as revoked
+func TestEncodingProvesStatus(t *testing.T) {
+	for _, tc := range []struct {
+		encoding string
+		validErr error
+	}{
+		{EncodingList, nil},
+		{EncodingTruncated, nil},
+		{EncodingCascade, nil},
+		{EncodingBloom, ErrDslNotProven},
+	} {
+		t.Run(tc.encoding, func(t *testing.T) {
+			s := newTestServer(t)
+			s.Config.Encoding = tc.encoding
+			s.Config.RevocationOnly = tc.encoding == EncodingBloom
+			if err := s.Config.Validate(); err != nil {
+				t.Fatal(err)
+			}
+			// Other entries in every status fill the filters
+			statuses := []EntryStatus{StatusValid, StatusSuspended, StatusRevoked}
+			for i := 0; i < 30; i++ {
+				jti := fmt.Sprintf("%032x", i+1)
+				(*s.Dsl)[jti] = DslEntry{Status: statuses[i%len(statuses)], SeedVersion: currentSeedVersion}
+			}
+			jti := "4e1a7c0b2d9f4c35a1e8b6d0f3c2a917"
+			(*s.Dsl)[jti] = DslEntry{Status: StatusValid, SeedVersion: currentSeedVersion}
+
+			opts := VerifyOptions{Audience: "https://verifier.example", Nonce: "8f2a1c"}
+			holderKey := newTestHolderKey(t)
+			credential := newTestCredential(t, s, jti, holderKey)
+			check := func() (*VerificationResult, error) {
+				t.Helper()
+				publishTestWindows(t, s)
+				v := NewVerifier(VerifyOptions{})
+				for _, w := range s.DslWindows {
+					if _, err := v.Load([]byte(w.DslJwt)); err != nil {
+						t.Fatal(err)
+					}
+				}
+				h, err := LoadHolderProof(writeTestProof(t, s, jti, credential, holderKey, opts.Audience, opts.Nonce), opts)
+				if err != nil {
+					t.Fatal(err)
+				}
+				return v.Check(h)
+			}
+
+			result, err := check()
+			if !errors.Is(err, tc.validErr) {
+				t.Fatalf("valid entry: got %v, want %v", err, tc.validErr)
+			}
+			if err == nil && result.Status != StatusValid {
+				t.Fatalf("got status %s, want %s", result.Status, StatusValid)
+			}
+
+			(*s.Dsl)[jti] = DslEntry{Status: StatusRevoked, SeedVersion: currentSeedVersion}
+			result, err = check()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if result.Status != StatusRevoked {
+				t.Fatalf("got status %s, want %s", result.Status, StatusRevoked)
+			}
+		})
+	}
+}
+
+func TestBloomEncodingRequiresRevocationOnly(t *testing.T) {
+	config := DefaultListConfig()
+	config.Encoding = EncodingBloom
+	if err := config.Validate(); err == nil {
+		t.Fatal("the bloom encoding was accepted without revocation only")
+	}
+	config.RevocationOnly = true
+	if err := config.Validate(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestBloomFilterDecodeBounds(t *testing.T) {
+	data := base64.RawURLEncoding.EncodeToString(make([]byte, 8))
+	for _, tc := range []struct {
+		name string
+		f    BloomFilter
+	}{
+		{"no hash functions", BloomFilter{M: 64, K: 0, Data: data}},
+		{"too many hash functions", BloomFilter{M: 64, K: maxBloomHashes + 1, Data: data}},
+		{"no bits", BloomFilter{M: 0, K: 1, Data: data}},
+		{"more bits than the array", BloomFilter{M: 65, K: 1, Data: data}},
+		{"fewer bits than the array", BloomFilter{M: 56, K: 1, Data: data}},
+		{"overflowing bit count", BloomFilter{M: math.MaxUint64, K: 1, Data: data}},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			if err := tc.f.decode(); err == nil {
+				t.Fatal("invalid Bloom filter accepted")
+			}
+		})
+	}
+
+	f := BloomFilter{M: 60, K: maxBloomHashes, Data: data}
+	if err := f.decode(); err != nil {
+		t.Fatal(err)
+	}
+	// Small filters keep at most maxBloomHashes hash functions
+	if f := NewBloomFilter(nil, 0x1p-40, 0); f.K > maxBloomHashes {
+		t.Fatalf("got %d hash functions, at most %d are decoded", f.K, maxBloomHashes)
+	}
+}
@@ -0,0 +1,130 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// EntryStatus is the status of a credential in the dSL
+type EntryStatus string
+
+const (
+	StatusValid     EntryStatus = "valid"
+	StatusSuspended EntryStatus = "suspended" // temporarily blocked, can be reinstated
+	StatusRevoked   EntryStatus = "revoked"
+)
+
+// Statuses a verifier can encounter
+var entryStatuses = []EntryStatus{StatusValid, StatusSuspended, StatusRevoked}
+
+// ReasonCode is a revocation reason as defined in RFC 5280 (CRLReason)
+type ReasonCode int
+
+const (
+	ReasonUnspecified          ReasonCode = 0
+	ReasonKeyCompromise        ReasonCode = 1
+	ReasonCACompromise         ReasonCode = 2
+	ReasonAffiliationChanged   ReasonCode = 3
+	ReasonSuperseded           ReasonCode = 4
+	ReasonCessationOfOperation ReasonCode = 5
+	ReasonCertificateHold      ReasonCode = 6
+	// value 7 is not used
+	ReasonRemoveFromCRL      ReasonCode = 8
+	ReasonPrivilegeWithdrawn ReasonCode = 9
+	ReasonAACompromise       ReasonCode = 10
+)
+
+var reasonNames = map[ReasonCode]string{
+	ReasonUnspecified:          "unspecified",
+	ReasonKeyCompromise:        "keyCompromise",
+	ReasonCACompromise:         "cACompromise",
+	ReasonAffiliationChanged:   "affiliationChanged",
+	ReasonSuperseded:           "superseded",
+	ReasonCessationOfOperation: "cessationOfOperation",
+	ReasonCertificateHold:      "certificateHold",
+	ReasonRemoveFromCRL:        "removeFromCRL",
+	ReasonPrivilegeWithdrawn:   "privilegeWithdrawn",
+	ReasonAACompromise:         "aACompromise",
+}
+
+func (r ReasonCode) String() string {
+	if name, ok := reasonNames[r]; ok {
+		return name
+	}
+	return fmt.Sprintf("reason(%d)", int(r))
+}
+
+// ParseReasonCode parses a reason code given by its RFC 5280 name or number
+func ParseReasonCode(s string) (ReasonCode, error) {
+	if n, err := strconv.Atoi(s); err == nil {
+		if _, ok := reasonNames[ReasonCode(n)]; ok {
+			return ReasonCode(n), nil
+		}
+		return 0, fmt.Errorf("unknown reason code %d", n)
+	}
+	for code, name := range reasonNames {
+		if strings.EqualFold(name, s) {
+			return code, nil
+		}
+	}
+	return 0, fmt.Errorf("unknown reason %q", s)
+}
+
+// StatusMetadata describes the last status change of a credential
+type StatusMetadata struct {
+	Time   int64      `json:"time"`            // unix time of the status change
+	Reason ReasonCode `json:"reason"`          // RFC 5280 reason code
+	Actor  string     `json:"actor,omitempty"` // operator who changed the status
+	Note   string     `json:"note,omitempty"`  // free-text note
+}
+
+// DslEntry is the status record of a credential in the dSL map
+type DslEntry struct {
+	Status   EntryStatus     `json:"status"`
+	Created  int64           `json:"created,omitempty"`  // unix time the entry was registered
+	Metadata *StatusMetadata `json:"metadata,omitempty"` // set when the status changes
+	Arkg     *ArkgSeed       `json:"arkg,omitempty"`     // seed material of the ARKG seed mode
+
+	// Seed derivation of the entry, entries without a version use the legacy derivation
+	SeedVersion int `json:"seed_version,omitempty"`
+}
+
+// CanChangeTo checks whether the entry can move to the given status
+// valid -> suspended, valid -> revoked, suspended -> valid, suspended -> revoked
+func (e DslEntry) CanChangeTo(status EntryStatus) error {
+	switch {
+	case e.Status == StatusRevoked:
+		return errors.New("jti is revoked; revocation is final")
+	case e.Status == status:
+		return fmt.Errorf("jti is already %s", status)
+	case status == StatusValid && e.Status != StatusSuspended:
+		return errors.New("only suspended credentials can be reinstated")
+	}
+	return nil
+}
+
+// Decode a dSL map entry
+// The legacy dsl-map.json format stores a boolean (true: valid, false: revoked)
+func decodeDslEntry(raw json.RawMessage) (DslEntry, bool, error) {
+	var valid bool
+	if err := json.Unmarshal(raw, &valid); err == nil {
+		if valid {
+			return DslEntry{Status: StatusValid}, true, nil
+		}
+		return DslEntry{Status: StatusRevoked}, true, nil
+	}
+
+	var entry DslEntry
+	if err := json.Unmarshal(raw, &entry); err != nil {
+		return DslEntry{}, false, err
+	}
+	switch entry.Status {
+	case StatusValid, StatusSuspended, StatusRevoked:
+	default:
+		return DslEntry{}, false, fmt.Errorf("unknown status %q", entry.Status)
+	}
+	return entry, false, nil
+}
@@ -0,0 +1,169 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"sort"
+	"time"
+)
+
+const dslEventLog = "dsl-events.jsonl"
+
+// Status events
+const (
+	EventNew       = "new"
+	EventRevoke    = "revoke"
+	EventSuspend   = "suspend"
+	EventReinstate = "reinstate"
+)
+
+// StatusEvent records a registration or a status change of a credential
+// Events are appended to the log and never modified
+type StatusEvent struct {
+	Time     int64           `json:"time"`     // unix time the status takes effect
+	Recorded int64           `json:"recorded"` // unix time the event was written
+	Event    string          `json:"event"`
+	Jti      string          `json:"jti"`
+	Status   EntryStatus     `json:"status"` // status after the event
+	Metadata *StatusMetadata `json:"metadata,omitempty"`
+}
+
+// Event name of a status change
+func statusEventName(status EntryStatus) string {
+	switch status {
+	case StatusRevoked:
+		return EventRevoke
+	case StatusSuspended:
+		return EventSuspend
+	default:
+		return EventReinstate
+	}
+}
+
+// AppendEvent appends an event to the log
+func AppendEvent(path string, ev StatusEvent) error {
+	if ev.Recorded == 0 {
+		ev.Recorded = time.Now().Unix()
+	}
+	line, err := json.Marshal(ev)
+	if err != nil {
+		return fmt.Errorf("failed to marshal event: %w", err)
+	}
+
+	f, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
+	if err != nil {
+		return fmt.Errorf("failed to open event log: %w", err)
+	}
+	if _, err := f.Write(append(line, '\n')); err != nil {
+		f.Close()
+		return fmt.Errorf("failed to append event: %w", err)
+	}
+	return f.Close()
+}
+
+// LoadEvents reads all the events from the log
+// A missing log has no events
+func LoadEvents(path string) ([]StatusEvent, error) {
+	f, err := os.Open(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to open event log: %w", err)
+	}
+	defer f.Close()
+
+	var events []StatusEvent
+	scanner := bufio.NewScanner(f)
+	for n := 1; scanner.Scan(); n++ {
+		if len(scanner.Bytes()) == 0 {
+			continue
+		}
+		var ev StatusEvent
+		if err := json.Unmarshal(scanner.Bytes(), &ev); err != nil {
+			return nil, fmt.Errorf("invalid event on line %d: %w", n, err)
+		}
+		events = append(events, ev)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read event log: %w", err)
+	}
+	return events, nil
+}
+
+// ReplayEvents returns the dSL map as it was at time t
+// Entries without events (registered before the log existed) keep their current status,
+// entries registered before the log existed that changed later start out valid.
+// The seed material is taken from the current entries, events of unknown entries are skipped.
+func ReplayEvents(current map[string]DslEntry, events []StatusEvent, t int64) map[string]DslEntry {
+	// Apply the events in the order they took effect, keep the log order for equal times
+	sorted := make([]StatusEvent, len(events))
+	copy(sorted, events)
+	sort.SliceStable(sorted, func(i, j int) bool { return sorted[i].Time < sorted[j].Time })
+
+	logged := make(map[string]bool)
+	registered := make(map[string]bool)
+	for _, ev := range sorted {
+		logged[ev.Jti] = true
+		if ev.Event == EventNew {
+			registered[ev.Jti] = true
+		}
+	}
+
+	m := make(map[string]DslEntry, len(current))
+	for jti, entry := range current {
+		switch {
+		case !logged[jti]:
+			m[jti] = entry
+		case !registered[jti]:
+			// Only entries with the legacy seed predate the log, their seed material never changes
+			m[jti] = DslEntry{Status: StatusValid, Created: entry.Created, Arkg: entry.Arkg, SeedVersion: entry.SeedVersion}
+		}
+	}
+
+	for _, ev := range sorted {
+		if ev.Time > t {
+			break
+		}
+		seed, known := current[ev.Jti]
+		if !known {
+			continue
+		}
+		entry, ok := m[ev.Jti]
+		if !ok {
+			// The seed material never changes, whichever event comes first
+			entry = DslEntry{Status: ev.Status, Created: ev.Time, Arkg: seed.Arkg, SeedVersion: seed.SeedVersion}
+		}
+		if ev.Event == EventNew {
+			if !ok {
+				m[ev.Jti] = entry
+			}
+			continue
+		}
+		entry.Status = ev.Status
+		entry.Metadata = ev.Metadata
+		m[ev.Jti] = entry
+	}
+	return m
+}
+
+// DslAt returns the dSL map that was in force at time t
+// The caller reads the events once for all the windows it signs
+func (s *Server) DslAt(t int64, events []StatusEvent) *map[string]DslEntry {
+	current := s.dslSnapshot()
+
+	// The current map is up to date unless an event took effect after t
+	latest := int64(0)
+	for _, ev := range events {
+		latest = max(latest, ev.Time)
+	}
+	if t >= latest {
+		return current
+	}
+
+	m := ReplayEvents(*current, events, t)
+	return &m
+}
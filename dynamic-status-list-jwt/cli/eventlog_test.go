@@ -0,0 +1,35 @@
+package main
+
+import "testing"
+
+func TestReplayEventsSeedMaterial(t *testing.T) {
+	current := map[string]DslEntry{
+		"a": {Status: StatusRevoked, Created: 100, SeedVersion: SeedVersionHKDF},
+		"b": {Status: StatusValid, Created: 100, Arkg: &ArkgSeed{Kid: "k"}, SeedVersion: SeedVersionHKDF},
+	}
+	events := []StatusEvent{
+		{Time: 100, Event: EventNew, Jti: "a", Status: StatusValid},
+		{Time: 100, Event: EventNew, Jti: "b", Status: StatusValid},
+		// Backdated before the registration, written by an earlier version
+		{Time: 50, Event: EventRevoke, Jti: "a", Status: StatusRevoked},
+		// Registered by another process, not in the current entries
+		{Time: 120, Event: EventNew, Jti: "c", Status: StatusValid},
+	}
+
+	for _, at := range []int64{60, 150} {
+		m := ReplayEvents(current, events, at)
+		a, ok := m["a"]
+		if !ok || a.SeedVersion != SeedVersionHKDF || a.Status != StatusRevoked {
+			t.Fatalf("t=%d: got %+v, want the revoked entry with its seed version", at, a)
+		}
+		if _, ok := m["c"]; ok {
+			t.Fatalf("t=%d: an entry without seed material was replayed", at)
+		}
+	}
+	if b := ReplayEvents(current, events, 150)["b"]; b.Arkg == nil || b.SeedVersion != SeedVersionHKDF {
+		t.Fatalf("got %+v, want the ARKG seed material", b)
+	}
+	if _, ok := ReplayEvents(current, events, 60)["b"]; ok {
+		t.Fatal("an entry was replayed before its registration")
+	}
+}
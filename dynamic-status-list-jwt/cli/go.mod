@@ -3,8 +3,14 @@ module github.com/mynextid/dsl
 go 1.23.6
 
 require (
+	filippo.io/bigmod v0.1.0
 	github.com/lestrrat-go/jwx/v3 v3.0.0-alpha1
+	github.com/miekg/pkcs11 v1.1.2
 	github.com/spf13/cobra v1.8.1
+	go.etcd.io/bbolt v1.4.0
+	golang.org/x/crypto v0.33.0
+	golang.org/x/sys v0.30.0
+	golang.org/x/term v0.29.0
 )
 
 require (
@@ -17,6 +23,4 @@ require (
 	github.com/lestrrat-go/option v1.0.1 // indirect
 	github.com/segmentio/asm v1.2.0 // indirect
 	github.com/spf13/pflag v1.0.6 // indirect
-	golang.org/x/crypto v0.33.0 // indirect
-	golang.org/x/sys v0.30.0 // indirect
 )
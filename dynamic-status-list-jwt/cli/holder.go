@@ -1,6 +1,8 @@
 package main
 
 import (
+	"bytes"
+	"crypto"
 	"encoding/base64"
 	"encoding/hex"
 	"encoding/json"
@@ -13,7 +15,10 @@ import (
 )
 
 // Derive a new status list identifier as a holder/wallet
-func NewProof(in string, revoked bool, detached bool, timestamp int64) (*string, error) {
+// The dSL period is read from the verified window of the issuer's status list,
+// loaded from statusListPath or fetched from listURL if it is set
+// If the credential is bound to a holder key (cnf), the proof is signed with it
+func NewProof(in string, statusListPath string, listURL string, status EntryStatus, detached bool, timestamp int64, opts ProofOptions, verifyOpts VerifyOptions, cacheDir string) (*string, error) {
 
 	// read the file
 	data, err := os.ReadFile(in)
@@ -27,59 +32,268 @@ func NewProof(in string, revoked bool, detached bool, timestamp int64) (*string,
 		return nil, fmt.Errorf("invalid JSON format: %w", err)
 	}
 
-	if jwtData.PrivateMetadata == "" {
-		return nil, errors.New("private metadata missing")
+	jti, seed, err := loadPrivateMetadata(jwtData, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	// Get the current time
+	tNow := time.Now().Unix()
+	if timestamp != 0 {
+		// if timestamp is provided, use it
+		tNow = timestamp
+	}
+
+	// Read the dSL period from the verified status list
+	period, err := holderDslPeriod(jwtData, statusListPath, listURL, tNow, verifyOpts, cacheDir)
+	if err != nil {
+		return nil, err
 	}
 
-	t, err := jwt.Parse([]byte(jwtData.PrivateMetadata), jwt.WithVerify(false))
+	// Compute the revocation identifiers
+	reB64 := ComputeRevocationIdentifier(jti, seed, tNow, period, status)
+	token, err := NewToken(seed, tNow, period)
 	if err != nil {
 		return nil, err
 	}
+	tokenB64 := base64.RawURLEncoding.EncodeToString(token)
+
+	proof := HolderProofPayload{Jti: jti, Token: tokenB64, Sid: reB64, Iat: tNow, Revoked: status == StatusRevoked, Suspended: status == StatusSuspended, Aud: opts.Audience, Nonce: opts.Nonce}
+
+	// Sign the proof with the holder's key
+	proof.ProofJwt, err = signProof(jwtData, proof, opts)
+	if err != nil {
+		return nil, err
+	}
+	if proof.ProofJwt != "" {
+		// The verifier takes the holder key from the presented credential
+		proof.Credential = jwtData.Jwt
+	}
+
+	err = SaveJSON(proof, "holder_status-list-identifier.json")
+	if err != nil {
+		return nil, err
+	}
+	return &reB64, nil
+
+}
+
+// Holder proof payload
+type HolderProofPayload struct {
+	Jti        string `json:"jti"`
+	Token      string `json:"token"`
+	Sid        string `json:"sid"`
+	Iat        int64  `json:"iat"`
+	Revoked    bool   `json:"revoked"`
+	Suspended  bool   `json:"suspended,omitempty"`
+	Aud        string `json:"aud,omitempty"`
+	Nonce      string `json:"nonce,omitempty"`
+	ProofJwt   string `json:"proof_jwt,omitempty"`  // proof signed with the credential's cnf key
+	Credential string `json:"credential,omitempty"` // presented credential (JWT)
+
+	issuer string // iss of the verified credential, the status list must have the same
+}
+
+// Verified window of the credential's status list that contains t
+// The list is read from statusListPath, or fetched from listURL if it is set,
+// and must have the issuer of the credential
+// stale is set if the issuer could not be reached and a cached window is returned instead
+func holderStatusList(jwtData JWTData, statusListPath string, listURL string, t int64, opts VerifyOptions, cacheDir string) (list *StatusList, stale bool, err error) {
+	if listURL != "" {
+		list, stale, err = NewStatusClient(opts, cacheDir).StatusList(listURL, t)
+		if err != nil {
+			return nil, false, err
+		}
+	} else {
+		v := NewVerifier(opts)
+		if _, err := v.LoadFile(statusListPath); err != nil {
+			return nil, false, err
+		}
+		var ok bool
+		list, ok = v.List(t)
+		if !ok {
+			return nil, false, fmt.Errorf("%s has no window for the current time, fetch a fresh status list", statusListPath)
+		}
+	}
+	if err := checkListIssuer(jwtData, list); err != nil {
+		return nil, false, err
+	}
+	return list, stale, nil
+}
+
+// dSL period of the verified status list at time t
+// Identifiers can be precomputed for any time: without a window of the list file
+// for t, the period of its newest window is used
+func holderDslPeriod(jwtData JWTData, statusListPath string, listURL string, t int64, opts VerifyOptions, cacheDir string) (int64, error) {
+	if listURL != "" {
+		list, _, err := holderStatusList(jwtData, statusListPath, listURL, t, opts, cacheDir)
+		if err != nil {
+			return 0, err
+		}
+		return list.Period, nil
+	}
+
+	lists, err := NewVerifier(opts).LoadFile(statusListPath)
+	if err != nil {
+		return 0, fmt.Errorf("failed to load the status list: %w", err)
+	}
+	if len(lists) == 0 {
+		return 0, errors.New("status list is empty")
+	}
+	list := lists[0]
+	for _, l := range lists {
+		if l.Covers(t) {
+			list = l
+			break
+		}
+		if l.Nbf > list.Nbf {
+			list = l
+		}
+	}
+	if err := checkListIssuer(jwtData, list); err != nil {
+		return 0, err
+	}
+	return list.Period, nil
+}
+
+// The status list must have the issuer of the holder's credential
+// The holder's own credential is not verified, its iss only selects the list's issuer
+func checkListIssuer(jwtData JWTData, list *StatusList) error {
+	credential, err := jwt.Parse([]byte(jwtData.Jwt), jwt.WithVerify(false), jwt.WithValidate(false))
+	if err != nil {
+		return fmt.Errorf("credential: %w", err)
+	}
+	var iss, listIss string
+	if err := credential.Get(jwt.IssuerKey, &iss); err != nil || iss == "" {
+		return nil
+	}
+	if err := list.Token.Get(jwt.IssuerKey, &listIss); err != nil || listIss != iss {
+		return ErrProofIssuer
+	}
+	return nil
+}
+
+// Read the jti and the seed from the private status metadata
+// Encrypted private metadata is decrypted with the holder key
+// In the ARKG seed mode the seed is derived with the holder key, it is not in the metadata
+func loadPrivateMetadata(jwtData JWTData, opts ProofOptions) (string, []byte, error) {
+	if jwtData.PrivateMetadata == "" {
+		return "", nil, errors.New("private metadata missing")
+	}
+
+	signed, err := decryptPrivateMetadata(jwtData.PrivateMetadata, opts.decryptionKey(), opts.AllowPlaintextMetadata)
+	if err != nil {
+		return "", nil, err
+	}
+	t, err := jwt.Parse(signed, jwt.WithVerify(false))
+	if err != nil {
+		return "", nil, err
+	}
 
 	var jti string
 	err = t.Get(jwt.SubjectKey, &jti)
 	if err != nil {
-		return nil, err
+		return "", nil, err
+	}
+
+	if t.Has("arkg") {
+		var arkg map[string]interface{}
+		if err := t.Get("arkg", &arkg); err != nil {
+			return "", nil, err
+		}
+		handle, _ := arkg["kh"].(string)
+		issuerKey, _ := arkg["ipk"].(string)
+		key, err := LoadJWK(opts.decryptionKey())
+		if err != nil {
+			return "", nil, fmt.Errorf("failed to load the holder key: %w", err)
+		}
+		seed, err := holderArkgSeed(key, handle, issuerKey, jti)
+		if err != nil {
+			return "", nil, err
+		}
+		return jti, seed, nil
 	}
 
 	var seedHex string
 	err = t.Get("seed", &seedHex)
 	if err != nil {
-		return nil, err
+		return "", nil, err
 	}
 	seed, err := hex.DecodeString(seedHex)
+	if err != nil {
+		return "", nil, err
+	}
+	return jti, seed, nil
+}
+
+// WalletStatus checks the holder's own credential against the current status list
+// The list is read from statusListPath, or fetched from listURL if it is set
+func WalletStatus(in string, proofOpts ProofOptions, statusListPath string, listURL string, opts VerifyOptions, cacheDir string) (*VerificationResult, error) {
+	var jwtData JWTData
+	err := LoadJSON(&jwtData, in)
+	if err != nil {
+		return nil, err
+	}
+	jti, seed, err := loadPrivateMetadata(jwtData, proofOpts)
 	if err != nil {
 		return nil, err
 	}
 
-	// Get the current time
+	// Load the current window
 	tNow := time.Now().Unix()
-	if timestamp != 0 {
-		// if timestamp is provided, use it
-		tNow = timestamp
+	list, stale, err := holderStatusList(jwtData, statusListPath, listURL, tNow, opts, cacheDir)
+	if err != nil {
+		return nil, err
 	}
 
-	// Compute the revocation identifiers
-	reB64 := ComputeRevocationIdentifier(jti, seed, tNow, !revoked)
-	token, err := NewToken(seed, tNow)
+	// Compute the token of the window and look up the identifiers of all statuses
+	token, err := NewToken(seed, tNow, list.Period)
 	if err != nil {
 		return nil, err
 	}
-	tokenB64 := base64.RawURLEncoding.EncodeToString(token)
-
-	err = SaveJSON(HolderProofPayload{Jti: jti, Token: tokenB64, Sid: reB64, Iat: tNow, Revoked: revoked}, "holder_status-list-identifier.json")
+	h := HolderProofPayload{Jti: jti, Token: base64.RawURLEncoding.EncodeToString(token), Iat: tNow}
+	result, err := list.Check(h, opts)
 	if err != nil {
 		return nil, err
 	}
-	return &reB64, nil
-
+	result.Stale = stale
+	return result, nil
 }
 
-// Holder proof payload
-type HolderProofPayload struct {
-	Jti     string `json:"jti"`
-	Token   string `json:"token"`
-	Sid     string `json:"sid"`
-	Iat     int64  `json:"iat"`
-	Revoked bool   `json:"revoked"`
+// Sign the proof with the holder key the credential is bound to
+// Credentials without a cnf key get an unsigned proof
+func signProof(jwtData JWTData, proof HolderProofPayload, opts ProofOptions) (string, error) {
+	credential, err := jwt.Parse([]byte(jwtData.Jwt), jwt.WithVerify(false), jwt.WithValidate(false))
+	if err != nil {
+		return "", err
+	}
+	cnf, ok, err := CredentialKey(credential)
+	if err != nil {
+		return "", err
+	}
+	if !ok {
+		fmt.Println("> The credential is not bound to a holder key, the proof is not signed")
+		return "", nil
+	}
+
+	key, err := LoadJWK(opts.HolderKey)
+	if err != nil {
+		return "", fmt.Errorf("failed to load the holder key: %w", err)
+	}
+	pk, err := key.PublicKey()
+	if err != nil {
+		return "", err
+	}
+	keyThumbprint, err := pk.Thumbprint(crypto.SHA256)
+	if err != nil {
+		return "", err
+	}
+	cnfThumbprint, err := cnf.Thumbprint(crypto.SHA256)
+	if err != nil {
+		return "", err
+	}
+	if !bytes.Equal(keyThumbprint, cnfThumbprint) {
+		return "", fmt.Errorf("%s is not the key the credential is bound to", opts.HolderKey)
+	}
+	return signHolderProof(key, proof)
 }
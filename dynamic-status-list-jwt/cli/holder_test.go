@@ -0,0 +1,61 @@
+package main
+
+import (
+	"encoding/base64"
+	"errors"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// Status list file with the published windows of the issuer
+func writeTestWindows(t *testing.T, s *Server) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "dsl-windows.json")
+	if err := SaveJSON(s.DslWindows, path); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestHolderDslPeriodVerified(t *testing.T) {
+	s, jti, _ := newTestIssuerWithEntry(t)
+	jwtData := JWTData{Jwt: newTestCredential(t, s, jti, newTestHolderKey(t))}
+	nbf := s.DslWindows[1].Nbf
+
+	// The period of the issuer's list, also for times outside its windows
+	path := writeTestWindows(t, s)
+	for _, at := range []int64{nbf, nbf - 100*s.Config.Period} {
+		period, err := holderDslPeriod(jwtData, path, "", at, VerifyOptions{}, "")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if period != s.Config.Period {
+			t.Fatalf("got period %d, want %d", period, s.Config.Period)
+		}
+	}
+
+	// A list with another period, signed by another issuer
+	other := newTestServer(t)
+	other.Config.Period = 3600
+	publishTestWindows(t, other)
+	if _, err := holderDslPeriod(jwtData, writeTestWindows(t, other), "", nbf, VerifyOptions{}, ""); !errors.Is(err, ErrProofIssuer) {
+		t.Fatalf("got %v, want %v", err, ErrProofIssuer)
+	}
+
+	// A list whose period was changed after signing
+	parts := strings.Split(s.DslWindows[1].DslJwt, ".")
+	payload, err := base64.RawURLEncoding.DecodeString(parts[1])
+	if err != nil {
+		t.Fatal(err)
+	}
+	changed := strings.Replace(string(payload), `"prd":60`, `"prd":3600`, 1)
+	if changed == string(payload) {
+		t.Fatal("the list has no prd claim of 60 seconds")
+	}
+	parts[1] = base64.RawURLEncoding.EncodeToString([]byte(changed))
+	s.DslWindows = []DslJWT{{DslJwt: strings.Join(parts, "."), Nbf: nbf}}
+	if _, err := holderDslPeriod(jwtData, writeTestWindows(t, s), "", nbf, VerifyOptions{}, ""); err == nil {
+		t.Fatal("the period was read from a list with an invalid signature")
+	}
+}
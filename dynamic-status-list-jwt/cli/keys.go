@@ -0,0 +1,362 @@
+package main
+
+import (
+	"crypto"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/lestrrat-go/jwx/v3/jwa"
+	"github.com/lestrrat-go/jwx/v3/jwk"
+)
+
+const (
+	jwksFile                 = "dsl-jwks.json"     // published issuer keys
+	defaultKeyRetention      = 30 * 24 * time.Hour // a retired key stays in the JWKS this long
+	defaultKeyPrepublication = time.Duration(0)    // a new key is in the JWKS this long before it signs
+)
+
+// IssuerKey is an issuer signing key with its rotation schedule
+//
+//	activates: the key signs from this time on
+//	retired:   the key stops signing, it is still published so its lists keep verifying
+//	expires:   the key is removed from the JWKS, its lists no longer verify
+//
+// The private key is kept after it expires, the seeds of older entries may depend on it
+type IssuerKey struct {
+	Kid       string          `json:"kid"`
+	Created   int64           `json:"created"`
+	Activates int64           `json:"activates"`
+	Retired   int64           `json:"retired,omitempty"`
+	Expires   int64           `json:"expires,omitempty"`
+	JWK       json.RawMessage `json:"jwk"`             // private JWK, the public JWK of a token key
+	Token     string          `json:"token,omitempty"` // CKA_ID of the private key in the PKCS#11 token (hex)
+
+	pub    jwk.Key                // public key with kid
+	alg    jwa.SignatureAlgorithm // signature algorithm
+	signer IssuerSigner           // private key operations, nil until the token is open
+}
+
+// KeyRing holds the issuer keys, oldest first
+type KeyRing struct {
+	Keys []*IssuerKey `json:"keys"`
+}
+
+// Signs reports whether the key signs at time t
+func (k *IssuerKey) Signs(t int64) bool {
+	return k.Activates <= t && (k.Retired == 0 || t < k.Retired)
+}
+
+// Published reports whether the key is in the JWKS at time t
+func (k *IssuerKey) Published(t int64) bool {
+	return k.Expires == 0 || t < k.Expires
+}
+
+// Hex encoded JWK thumbprint of the key
+func (k *IssuerKey) Thumbprint() (string, error) {
+	thumbprint, err := k.pub.Thumbprint(crypto.SHA256)
+	if err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(thumbprint), nil
+}
+
+// Backend of the private key
+func (k *IssuerKey) Backend() string {
+	if k.Token != "" {
+		return KeyBackendPKCS11
+	}
+	return KeyBackendSoftware
+}
+
+// Signer performs the private key operations of the key
+func (k *IssuerKey) Signer() (IssuerSigner, error) {
+	if k.signer == nil {
+		return nil, fmt.Errorf("issuer key %s is in a PKCS#11 token, set the %q key backend", k.Kid, KeyBackendPKCS11)
+	}
+	return k.signer, nil
+}
+
+// Parse the stored JWK
+func (k *IssuerKey) parse() error {
+	key, err := jwk.ParseKey(k.JWK)
+	if err != nil {
+		return fmt.Errorf("failed to parse the issuer key %s: %w", k.Kid, err)
+	}
+	return k.setKey(key)
+}
+
+// Set the stored key and derive the public key
+// A software key is a private key that signs in process, a token key is a public key
+func (k *IssuerKey) setKey(key jwk.Key) error {
+	if err := key.Set(jwk.KeyIDKey, k.Kid); err != nil {
+		return err
+	}
+	alg, err := keyAlgorithm(key)
+	if err != nil {
+		return err
+	}
+	pub, err := key.PublicKey()
+	if err != nil {
+		return err
+	}
+	if k.Token == "" {
+		signer, err := newSoftwareSigner(key)
+		if err != nil {
+			return err
+		}
+		k.signer = signer
+	}
+	k.pub = pub
+	k.alg = alg
+	k.JWK, err = json.Marshal(key)
+	return err
+}
+
+// NewIssuerKey wraps a private key, or the public key of the token key with the given CKA_ID
+// The kid is the JWK thumbprint
+func NewIssuerKey(key jwk.Key, token string, activates int64) (*IssuerKey, error) {
+	thumbprint, err := key.Thumbprint(crypto.SHA256)
+	if err != nil {
+		return nil, err
+	}
+	k := &IssuerKey{
+		Kid:       base64.RawURLEncoding.EncodeToString(thumbprint),
+		Created:   time.Now().Unix(),
+		Activates: activates,
+		Token:     token,
+	}
+	if err := k.setKey(key); err != nil {
+		return nil, err
+	}
+	return k, nil
+}
+
+// Generate a new issuer key for the signature algorithm in the key backend
+func generateIssuerKey(backend KeyBackend, alg string, activates int64) (*IssuerKey, error) {
+	if err := validateAlgorithm(alg); err != nil {
+		return nil, err
+	}
+	fmt.Printf("Generating new %s key\n", alg)
+	sig, _ := jwa.LookupSignatureAlgorithm(alg)
+	return backend.Generate(sig, activates)
+}
+
+// Parse the keys of a stored key ring
+func (r *KeyRing) parse() error {
+	if len(r.Keys) == 0 {
+		return errors.New("the issuer key ring is empty")
+	}
+	for _, k := range r.Keys {
+		if err := k.parse(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Decode a stored key ring
+// A single JWK is the format before key rotation, it becomes the first key of the ring
+func decodeKeyRing(data []byte) (*KeyRing, bool, error) {
+	var ring KeyRing
+	if err := json.Unmarshal(data, &ring); err != nil {
+		return nil, false, fmt.Errorf("failed to parse the issuer keys: %w", err)
+	}
+	if ring.Keys != nil {
+		if err := ring.parse(); err != nil {
+			return nil, false, err
+		}
+		return &ring, false, nil
+	}
+
+	key, err := jwk.ParseKey(data)
+	if err != nil {
+		return nil, false, fmt.Errorf("failed to parse JWK: %w", err)
+	}
+	k, err := NewIssuerKey(key, "", 0)
+	if err != nil {
+		return nil, false, err
+	}
+	k.Created = 0
+	return &KeyRing{Keys: []*IssuerKey{k}}, true, nil
+}
+
+// Issuer identifier of the lists and credentials: the configured id, or the thumbprint of the first key
+// It does not change when the keys rotate, the kid header selects the key
+func (r *KeyRing) Issuer(configured string) (string, error) {
+	if configured != "" {
+		return configured, nil
+	}
+	return r.Keys[0].Thumbprint()
+}
+
+// Active returns the key that signs at time t, the most recently activated one
+func (r *KeyRing) Active(t int64) (*IssuerKey, error) {
+	var active *IssuerKey
+	for _, k := range r.Keys {
+		if k.Signs(t) && (active == nil || k.Activates >= active.Activates) {
+			active = k
+		}
+	}
+	if active == nil {
+		return nil, errors.New("no issuer key is active, run 'dsl keys rotate'")
+	}
+	return active, nil
+}
+
+// Key returns the key with the given kid
+func (r *KeyRing) Key(kid string) (*IssuerKey, bool) {
+	for _, k := range r.Keys {
+		if k.Kid == kid {
+			return k, true
+		}
+	}
+	return nil, false
+}
+
+// Legacy returns the first key of the issuer
+// The legacy seeds and the ARKG entries without a kid were derived with it
+func (r *KeyRing) Legacy() *IssuerKey {
+	return r.Keys[0]
+}
+
+// JWKS returns the public keys published at time t
+func (r *KeyRing) JWKS(t int64) (jwk.Set, error) {
+	set := jwk.NewSet()
+	for _, k := range r.Keys {
+		if !k.Published(t) {
+			continue
+		}
+		if err := set.AddKey(k.pub); err != nil {
+			return nil, err
+		}
+	}
+	return set, nil
+}
+
+// Rotate adds a new key for the algorithm that signs after the prepublication delay
+// The keys that sign until then are retired when the new key activates and expire after the retention
+func (r *KeyRing) Rotate(backend KeyBackend, alg string, prepublication time.Duration, retention time.Duration) (*IssuerKey, error) {
+	if prepublication < 0 || retention <= 0 {
+		return nil, errors.New("the prepublication must not be negative and the retention must be positive")
+	}
+	activates := time.Now().Add(prepublication).Unix()
+	key, err := generateIssuerKey(backend, alg, activates)
+	if err != nil {
+		return nil, err
+	}
+	for _, k := range r.Keys {
+		if k.Retired == 0 || k.Retired > activates {
+			k.Retired = activates
+			k.Expires = activates + int64(retention.Seconds())
+		}
+	}
+	r.Keys = append(r.Keys, key)
+	return key, nil
+}
+
+// Print the keys and their schedule
+func (r *KeyRing) Print() {
+	now := time.Now().Unix()
+	date := func(t int64) string {
+		if t == 0 {
+			return "-"
+		}
+		return time.Unix(t, 0).UTC().Format(time.RFC3339)
+	}
+	for _, k := range r.Keys {
+		state := "retired"
+		switch {
+		case k.Signs(now):
+			state = "active"
+		case k.Activates > now:
+			state = "published"
+		case !k.Published(now):
+			state = "expired"
+		}
+		fmt.Printf("> %s  %-5s  %-8s  %-9s  activates: %s  retired: %s  expires: %s\n", k.Kid, k.alg, k.Backend(), state, date(k.Activates), date(k.Retired), date(k.Expires))
+	}
+}
+
+// Load or generate the issuer keys, a new key signs with alg
+// The signers of the token keys are attached by the key backend
+// The first key is only stored in plaintext if allowPlaintext is set, otherwise the keystore needs a passphrase
+func getServerKeys(store Store, backend KeyBackend, alg string, allowPlaintext bool) (*KeyRing, error) {
+	ring, err := store.LoadIssuerKeys()
+	if err == nil {
+		// Keys loaded, exit
+		return ring, backend.Attach(ring)
+	}
+	if !errors.Is(err, os.ErrNotExist) {
+		return nil, err
+	}
+
+	if err := requireKeystorePassphrase(allowPlaintext); err != nil {
+		return nil, err
+	}
+
+	// Only one process generates the key
+	unlock, err := LockFile(stateLockFile, true)
+	if err != nil {
+		return nil, err
+	}
+	defer unlock()
+	ring, err = store.LoadIssuerKeys()
+	if err == nil {
+		// Key created by another process
+		return ring, backend.Attach(ring)
+	}
+
+	// No keys yet, generate the first one
+	key, err := generateIssuerKey(backend, alg, 0)
+	if err != nil {
+		return nil, err
+	}
+	ring = &KeyRing{Keys: []*IssuerKey{key}}
+
+	// Save to the store
+	err = store.SaveIssuerKeys(ring)
+	if err != nil {
+		return nil, fmt.Errorf("failed to store: %w", err)
+	}
+	if !keystoreSealed() {
+		fmt.Println("> The issuer keys are stored unencrypted, run 'dsl keys encrypt' to protect them with a passphrase")
+	}
+	return ring, nil
+}
+
+// RotateKeys adds a new issuer key for the configured algorithm and publishes the lists with the new schedule
+func (s *Server) RotateKeys(prepublication time.Duration, retention time.Duration) (*IssuerKey, error) {
+	unlock, err := LockFile(stateLockFile, true)
+	if err != nil {
+		return nil, err
+	}
+	defer unlock()
+
+	// Rotate the stored keys, another process may have rotated them
+	ring, err := s.store.LoadIssuerKeys()
+	if err != nil {
+		return nil, err
+	}
+	if err := s.backend.Attach(ring); err != nil {
+		return nil, err
+	}
+	key, err := ring.Rotate(s.backend, s.Config.SigningAlgorithm(), prepublication, retention)
+	if err != nil {
+		return nil, err
+	}
+	if err := s.store.SaveIssuerKeys(ring); err != nil {
+		return nil, err
+	}
+	s.setKeys(ring)
+
+	// Publish the lists and the JWKS
+	if err := s.NewDsl(); err != nil {
+		return nil, err
+	}
+	return key, s.publishDslJwtAt(time.Now().Unix())
+}
@@ -0,0 +1,544 @@
+package main
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/crypto/argon2"
+	"golang.org/x/term"
+)
+
+// Sources of the keystore passphrase, the terminal is prompted when neither is set
+const (
+	keystorePassphraseEnv     = "DSL_KEYSTORE_PASSPHRASE"      // the passphrase
+	keystorePassphraseFileEnv = "DSL_KEYSTORE_PASSPHRASE_FILE" // path of a file with the passphrase
+)
+
+const (
+	keystoreFormat        = "dsl/v1 keystore" // format of the sealed secrets
+	keystoreKDFAlg        = "argon2id"
+	keystoreSaltSize      = 16
+	keystoreKeySize       = 32 // AES-256-GCM
+	minPassphraseLength   = 8
+	defaultArgon2Time     = 3
+	defaultArgon2MemoryKB = 64 * 1024
+	defaultArgon2Threads  = 4
+)
+
+// Passphrase-derived key parameters, stored with the sealed secret
+type keystoreKDF struct {
+	Alg     string `json:"alg"`
+	Salt    []byte `json:"salt"` // base64
+	Time    uint32 `json:"t"`
+	Memory  uint32 `json:"m"` // KiB
+	Threads uint8  `json:"p"`
+}
+
+// Secret issuer state (the issuer keys or the seed master) encrypted with a passphrase-derived key
+//
+//	key        = Argon2id(passphrase, salt, t, m, p)
+//	ciphertext = AES-256-GCM(key, nonce, plaintext, aad = format || kdf)
+type sealedSecret struct {
+	Format     string      `json:"keystore"`
+	KDF        keystoreKDF `json:"kdf"`
+	Nonce      []byte      `json:"nonce"`      // base64
+	Ciphertext []byte      `json:"ciphertext"` // base64
+}
+
+// Keystore passphrase and the key derived from it, cached for the process
+// The secrets sealed by a process share the salt, so the key is derived once
+var keystore struct {
+	sync.Mutex
+	passphrase []byte
+	kdf        keystoreKDF
+	key        []byte
+}
+
+// Derive the AEAD key of a passphrase
+func (k keystoreKDF) derive(passphrase []byte) ([]byte, error) {
+	if k.Alg != keystoreKDFAlg || k.Time == 0 || k.Memory == 0 || k.Threads == 0 || len(k.Salt) < keystoreSaltSize {
+		return nil, errors.New("invalid keystore key derivation parameters")
+	}
+	return argon2.IDKey(passphrase, k.Salt, k.Time, k.Memory, k.Threads, keystoreKeySize), nil
+}
+
+// Additional data of the AEAD: the format and the key derivation parameters
+func (s sealedSecret) aad() []byte {
+	kdf, _ := json.Marshal(s.KDF)
+	return append([]byte(s.Format), kdf...)
+}
+
+// Seal a secret with a passphrase, the key is derived with a fresh salt
+func sealWithPassphrase(plaintext []byte, passphrase []byte) ([]byte, error) {
+	kdf, key, err := newKeystoreKey(passphrase)
+	if err != nil {
+		return nil, err
+	}
+	return sealWithKey(plaintext, kdf, key)
+}
+
+// Key derivation parameters with a fresh salt, and the derived key
+func newKeystoreKey(passphrase []byte) (keystoreKDF, []byte, error) {
+	kdf := keystoreKDF{
+		Alg:     keystoreKDFAlg,
+		Salt:    make([]byte, keystoreSaltSize),
+		Time:    defaultArgon2Time,
+		Memory:  defaultArgon2MemoryKB,
+		Threads: defaultArgon2Threads,
+	}
+	if _, err := rand.Read(kdf.Salt); err != nil {
+		return kdf, nil, err
+	}
+	key, err := kdf.derive(passphrase)
+	return kdf, key, err
+}
+
+// Seal a secret with a derived key
+func sealWithKey(plaintext []byte, kdf keystoreKDF, key []byte) ([]byte, error) {
+	aead, err := newKeystoreAEAD(key)
+	if err != nil {
+		return nil, err
+	}
+	s := sealedSecret{Format: keystoreFormat, KDF: kdf, Nonce: make([]byte, aead.NonceSize())}
+	if _, err := rand.Read(s.Nonce); err != nil {
+		return nil, err
+	}
+	s.Ciphertext = aead.Seal(nil, s.Nonce, plaintext, s.aad())
+	return json.MarshalIndent(s, "", "  ")
+}
+
+// Open a sealed secret with a passphrase
+func openWithPassphrase(data []byte, passphrase []byte) ([]byte, error) {
+	s, ok := parseSealedSecret(data)
+	if !ok {
+		return nil, errors.New("not a sealed keystore")
+	}
+	key, err := s.KDF.derive(passphrase)
+	if err != nil {
+		return nil, err
+	}
+	return s.open(key)
+}
+
+// Decrypt with the derived key, a wrong passphrase fails the authentication
+func (s sealedSecret) open(key []byte) ([]byte, error) {
+	aead, err := newKeystoreAEAD(key)
+	if err != nil {
+		return nil, err
+	}
+	if len(s.Nonce) != aead.NonceSize() {
+		return nil, errors.New("invalid keystore nonce")
+	}
+	plaintext, err := aead.Open(nil, s.Nonce, s.Ciphertext, s.aad())
+	if err != nil {
+		return nil, errors.New("failed to decrypt the keystore: wrong passphrase or corrupted data")
+	}
+	return plaintext, nil
+}
+
+func newKeystoreAEAD(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// Parse a sealed secret, plaintext data is not one
+func parseSealedSecret(data []byte) (sealedSecret, bool) {
+	var s sealedSecret
+	if err := json.Unmarshal(data, &s); err != nil || s.Format != keystoreFormat {
+		return s, false
+	}
+	return s, true
+}
+
+// Seal secret issuer state with the keystore passphrase
+// Without a passphrase (environment or a previously opened keystore) the state is stored in plaintext
+func sealSecret(plaintext []byte) ([]byte, error) {
+	keystore.Lock()
+	defer keystore.Unlock()
+	if keystore.key == nil {
+		passphrase, err := configuredPassphrase()
+		if err != nil || passphrase == nil {
+			return plaintext, err
+		}
+		if err := setKeystorePassphraseLocked(passphrase); err != nil {
+			return nil, err
+		}
+	}
+	return sealWithKey(plaintext, keystore.kdf, keystore.key)
+}
+
+// Open secret issuer state, plaintext state is returned as is
+// A sealed state needs the passphrase: from the environment, or prompted on the terminal
+func openSecret(data []byte) ([]byte, bool, error) {
+	s, ok := parseSealedSecret(data)
+	if !ok {
+		return data, false, nil
+	}
+	keystore.Lock()
+	defer keystore.Unlock()
+	if keystore.key != nil && bytes.Equal(keystore.kdf.Salt, s.KDF.Salt) {
+		plaintext, err := s.open(keystore.key)
+		return plaintext, true, err
+	}
+
+	passphrase := keystore.passphrase
+	if passphrase == nil {
+		var err error
+		if passphrase, err = configuredPassphrase(); err != nil {
+			return nil, true, err
+		}
+	}
+	if passphrase == nil {
+		var err error
+		if passphrase, err = promptPassphrase("Keystore passphrase: ", false); err != nil {
+			return nil, true, fmt.Errorf("the issuer keystore is encrypted, set %s or %s: %w", keystorePassphraseEnv, keystorePassphraseFileEnv, err)
+		}
+	}
+	key, err := s.KDF.derive(passphrase)
+	if err != nil {
+		return nil, true, err
+	}
+	plaintext, err := s.open(key)
+	if err != nil {
+		return nil, true, err
+	}
+	// Seal the state of this process with the same key
+	keystore.passphrase, keystore.kdf, keystore.key = passphrase, s.KDF, key
+	return plaintext, true, nil
+}
+
+// Whether secret state is sealed when it is stored
+func keystoreSealed() bool {
+	keystore.Lock()
+	defer keystore.Unlock()
+	if keystore.key != nil {
+		return true
+	}
+	passphrase, err := configuredPassphrase()
+	return err == nil && passphrase != nil
+}
+
+// A new keystore is sealed: with the passphrase of the environment, or a new one entered on the terminal
+// It is only stored in plaintext if allowPlaintext is set
+func requireKeystorePassphrase(allowPlaintext bool) error {
+	if allowPlaintext || keystoreSealed() {
+		return nil
+	}
+	passphrase, err := newPassphrase("", "New keystore passphrase: ")
+	if err != nil {
+		return fmt.Errorf("the issuer keys would be stored unencrypted, set %s or %s, or use --allow-plaintext-keystore: %w", keystorePassphraseEnv, keystorePassphraseFileEnv, err)
+	}
+	return setKeystorePassphrase(passphrase)
+}
+
+// Set the passphrase of the keystore, the secrets are sealed with it from now on
+func setKeystorePassphrase(passphrase []byte) error {
+	keystore.Lock()
+	defer keystore.Unlock()
+	return setKeystorePassphraseLocked(passphrase)
+}
+
+func setKeystorePassphraseLocked(passphrase []byte) error {
+	kdf, key, err := newKeystoreKey(passphrase)
+	if err != nil {
+		return err
+	}
+	keystore.passphrase, keystore.kdf, keystore.key = passphrase, kdf, key
+	return nil
+}
+
+// Passphrase of the environment, nil if none is set
+func configuredPassphrase() ([]byte, error) {
+	if passphrase := os.Getenv(keystorePassphraseEnv); passphrase != "" {
+		return []byte(passphrase), nil
+	}
+	if path := os.Getenv(keystorePassphraseFileEnv); path != "" {
+		return readPassphraseFile(path)
+	}
+	return nil, nil
+}
+
+// Read a passphrase from the first line of a file
+func readPassphraseFile(path string) ([]byte, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read the passphrase file: %w", err)
+	}
+	passphrase, _, _ := strings.Cut(string(data), "\n")
+	passphrase = strings.TrimSuffix(passphrase, "\r")
+	if passphrase == "" {
+		return nil, fmt.Errorf("the passphrase file %s is empty", path)
+	}
+	return []byte(passphrase), nil
+}
+
+// Prompt for a passphrase on the terminal, a new passphrase is entered twice
+func promptPassphrase(prompt string, confirm bool) ([]byte, error) {
+	fd := int(os.Stdin.Fd())
+	if !term.IsTerminal(fd) {
+		return nil, errors.New("no terminal to prompt for the passphrase")
+	}
+	fmt.Fprint(os.Stderr, prompt)
+	passphrase, err := term.ReadPassword(fd)
+	fmt.Fprintln(os.Stderr)
+	if err != nil {
+		return nil, err
+	}
+	if !confirm {
+		return passphrase, nil
+	}
+	fmt.Fprint(os.Stderr, "Repeat the passphrase: ")
+	repeated, err := term.ReadPassword(fd)
+	fmt.Fprintln(os.Stderr)
+	if err != nil {
+		return nil, err
+	}
+	if !bytes.Equal(passphrase, repeated) {
+		return nil, errors.New("the passphrases do not match")
+	}
+	return passphrase, nil
+}
+
+// New passphrase from a file, or prompted on the terminal
+func newPassphrase(path string, prompt string) ([]byte, error) {
+	var passphrase []byte
+	var err error
+	if path != "" {
+		passphrase, err = readPassphraseFile(path)
+	} else {
+		passphrase, err = promptPassphrase(prompt, true)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if len(passphrase) < minPassphraseLength {
+		return nil, fmt.Errorf("the passphrase must have at least %d characters", minPassphraseLength)
+	}
+	return passphrase, nil
+}
+
+// Existing passphrase from a file, or prompted on the terminal
+func existingPassphrase(path string, prompt string) ([]byte, error) {
+	if path != "" {
+		return readPassphraseFile(path)
+	}
+	return promptPassphrase(prompt, false)
+}
+
+// SaveSecretJSON saves secret issuer state to a JSON file, sealed when the keystore has a passphrase
+func SaveSecretJSON(variable interface{}, path string) error {
+	data, err := json.MarshalIndent(variable, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to marshal: %w", err)
+	}
+	if data, err = sealSecret(data); err != nil {
+		return err
+	}
+	if err := WriteFileAtomic(path, data, 0600); err != nil {
+		return fmt.Errorf("failed to save : %w", err)
+	}
+	return nil
+}
+
+// LoadSecretJSON loads secret issuer state from a JSON file, plaintext or sealed
+// Plaintext state is reported for migration when the keystore has a passphrase
+func LoadSecretJSON(variable interface{}, path string) (bool, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return false, err
+	}
+	data, sealed, err := openSecret(data)
+	if err != nil {
+		return false, err
+	}
+	if err := json.Unmarshal(data, variable); err != nil {
+		return false, fmt.Errorf("failed to parse JSON: %w", err)
+	}
+	return !sealed && keystoreSealed(), nil
+}
+
+// Backup of the issuer keys and the seed master, sealed with its own passphrase
+// Token keys are backed up as their public JWK and CKA_ID, the private keys stay in the token
+type keystoreBackup struct {
+	Created    int64    `json:"created"`
+	Keys       *KeyRing `json:"keys"`
+	SeedMaster []byte   `json:"seed_master"` // base64
+}
+
+// EncryptKeystore seals the stored issuer keys and seed master with a new passphrase
+// A plaintext keystore is migrated, an encrypted one changes its passphrase
+func (s *Server) EncryptKeystore(passphrase []byte) error {
+	unlock, err := LockFile(stateLockFile, true)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	// Load with the current passphrase, store with the new one
+	ring, err := s.store.LoadIssuerKeys()
+	if err != nil {
+		return err
+	}
+	master, err := s.store.LoadSeedMaster()
+	if err != nil {
+		return err
+	}
+	if err := setKeystorePassphrase(passphrase); err != nil {
+		return err
+	}
+	if err := s.store.SaveIssuerKeys(ring); err != nil {
+		return err
+	}
+	return s.store.SaveSeedMaster(master)
+}
+
+// ExportKeys writes a backup of the issuer keys and the seed master sealed with the passphrase
+func (s *Server) ExportKeys(path string, passphrase []byte) error {
+	data, err := s.keystoreSnapshot()
+	if err != nil {
+		return err
+	}
+	if data, err = sealWithPassphrase(data, passphrase); err != nil {
+		return err
+	}
+	return WriteFileAtomic(path, data, 0600)
+}
+
+// ImportKeys restores the issuer keys and the seed master from a backup and publishes the lists with them
+func (s *Server) ImportKeys(path string, passphrase []byte, force bool) (*KeyRing, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	if data, err = openWithPassphrase(data, passphrase); err != nil {
+		return nil, err
+	}
+	return s.restoreKeystore(data, force)
+}
+
+// Plaintext backup of the stored issuer keys and seed master
+func (s *Server) keystoreSnapshot() ([]byte, error) {
+	unlock, err := LockFile(stateLockFile, false)
+	if err != nil {
+		return nil, err
+	}
+	defer unlock()
+
+	ring, err := s.store.LoadIssuerKeys()
+	if err != nil {
+		return nil, err
+	}
+	master, err := s.store.LoadSeedMaster()
+	if err != nil {
+		return nil, err
+	}
+	data, err := json.Marshal(keystoreBackup{Created: time.Now().Unix(), Keys: ring, SeedMaster: master})
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal: %w", err)
+	}
+	return data, nil
+}
+
+// Replace the issuer keys and the seed master with a plaintext backup
+// Replacing stored secrets that the seeds of registered entries depend on needs force
+func (s *Server) restoreKeystore(data []byte, force bool) (*KeyRing, error) {
+	var backup keystoreBackup
+	if err := json.Unmarshal(data, &backup); err != nil {
+		return nil, fmt.Errorf("failed to parse the backup: %w", err)
+	}
+	if backup.Keys == nil || len(backup.SeedMaster) < seedMasterSize {
+		return nil, errors.New("the backup has no issuer keys or seed master")
+	}
+	if err := backup.Keys.parse(); err != nil {
+		return nil, err
+	}
+	if err := s.backend.Attach(backup.Keys); err != nil {
+		return nil, err
+	}
+
+	unlock, err := LockFile(stateLockFile, true)
+	if err != nil {
+		return nil, err
+	}
+	entries, err := s.store.LoadEntries()
+	if err == nil && !force {
+		var changed int
+		changed, err = s.changedSeeds(entries, backup)
+		if err == nil && changed > 0 {
+			err = fmt.Errorf("the store has %d entries whose seeds depend on keys or a seed master the backup does not have, use --force to replace them", changed)
+		}
+	}
+	if err == nil {
+		err = s.store.SaveIssuerKeys(backup.Keys)
+	}
+	if err == nil {
+		err = s.store.SaveSeedMaster(backup.SeedMaster)
+	}
+	unlock()
+	if err != nil {
+		return nil, err
+	}
+
+	// Derive the seeds from the restored secrets
+	s.setKeys(backup.Keys)
+	s.Secret = legacySecret(backup.Keys)
+	s.seedKey = seedKeyOf(backup.SeedMaster)
+	s.seeds.Clear()
+	return backup.Keys, s.RecomputeDslJwt()
+}
+
+// Number of entries whose seeds the restored secrets would change
+// Restoring into a store without keys, or restoring the stored secrets, changes no seed
+func (s *Server) changedSeeds(entries map[string]DslEntry, backup keystoreBackup) (int, error) {
+	current, err := s.store.LoadIssuerKeys()
+	if errors.Is(err, os.ErrNotExist) {
+		current = nil
+	} else if err != nil {
+		return 0, err
+	}
+	master, err := s.store.LoadSeedMaster()
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return 0, err
+	}
+
+	// Whether the stored issuer key a seed was derived with is in the backup, the first key if kid is empty
+	restored := func(kid string) bool {
+		if current == nil {
+			return true
+		}
+		if kid == "" {
+			return current.Legacy().Kid == backup.Keys.Legacy().Kid
+		}
+		_, stored := current.Key(kid)
+		_, found := backup.Keys.Key(kid)
+		return !stored || found
+	}
+	changed := 0
+	for _, entry := range entries {
+		switch {
+		case entry.Arkg != nil:
+			if !restored(entry.Arkg.Kid) {
+				changed++
+			}
+		case entry.seedVersion() == SeedVersionLegacy:
+			if !restored("") {
+				changed++
+			}
+		case master != nil && !bytes.Equal(master, backup.SeedMaster):
+			changed++
+		}
+	}
+	return changed, nil
+}
@@ -1,10 +1,15 @@
 package main
 
+import "os"
+
 // Demo dSL JWT profile
 func main() {
 
 	// Initialize the server
 	s := NewServer()
+	if s == nil {
+		os.Exit(1)
+	}
 
 	// Process the CLI commands
 	s.Run()
@@ -0,0 +1,128 @@
+package main
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"time"
+
+	"golang.org/x/crypto/hkdf"
+)
+
+const (
+	metadataInfo      = "dsl/v1 status metadata" // HKDF info for the metadata encryption key
+	metadataBlockSize = 128                      // plaintexts are padded to a multiple of the block size
+)
+
+// StatusInfo is the status metadata encrypted for the holder
+// Operator identity and notes are never published
+type StatusInfo struct {
+	Status EntryStatus `json:"status"`
+	Time   int64       `json:"time,omitempty"`   // unix time of the last status change
+	Reason *ReasonCode `json:"reason,omitempty"` // RFC 5280 reason code
+}
+
+// NewStatusInfo returns the holder readable metadata of an entry
+func NewStatusInfo(entry DslEntry) StatusInfo {
+	info := StatusInfo{Status: entry.Status}
+	if entry.Metadata != nil {
+		reason := entry.Metadata.Reason
+		info.Time = entry.Metadata.Time
+		info.Reason = &reason
+	}
+	return info
+}
+
+// Derive the metadata encryption key from the time-based token
+// token = HMAC(seed, t), so only the holder and verifiers the holder shares the token with can derive the key
+func metadataKey(token []byte, jti string) ([]byte, error) {
+	jtiDigest := sha256.Sum256([]byte(jti))
+	key := make([]byte, 32)
+	if _, err := io.ReadFull(hkdf.New(sha256.New, token, jtiDigest[:], []byte(metadataInfo)), key); err != nil {
+		return nil, err
+	}
+	return key, nil
+}
+
+// EncryptStatusInfo encrypts the status metadata of a dSL entry
+// Result: base64url(nonce || AES-256-GCM(key, padded JSON, aad = sid))
+func EncryptStatusInfo(token []byte, jti string, sid string, info StatusInfo) (string, error) {
+	key, err := metadataKey(token, jti)
+	if err != nil {
+		return "", err
+	}
+	aead, err := newGCM(key)
+	if err != nil {
+		return "", err
+	}
+
+	plaintext, err := json.Marshal(info)
+	if err != nil {
+		return "", err
+	}
+	// Pad with whitespace so all blobs have the same length
+	padded := len(plaintext) + metadataBlockSize - len(plaintext)%metadataBlockSize
+	plaintext = append(plaintext, bytes.Repeat([]byte(" "), padded-len(plaintext))...)
+
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return "", err
+	}
+	ciphertext := aead.Seal(nonce, nonce, plaintext, []byte(sid))
+	return base64.RawURLEncoding.EncodeToString(ciphertext), nil
+}
+
+// DecryptStatusInfo decrypts the status metadata of a dSL entry with the holder's token
+func DecryptStatusInfo(token []byte, jti string, sid string, blob string) (*StatusInfo, error) {
+	data, err := base64.RawURLEncoding.DecodeString(blob)
+	if err != nil {
+		return nil, err
+	}
+	key, err := metadataKey(token, jti)
+	if err != nil {
+		return nil, err
+	}
+	aead, err := newGCM(key)
+	if err != nil {
+		return nil, err
+	}
+	if len(data) < aead.NonceSize() {
+		return nil, errors.New("status metadata is too short")
+	}
+
+	plaintext, err := aead.Open(nil, data[:aead.NonceSize()], data[aead.NonceSize():], []byte(sid))
+	if err != nil {
+		return nil, fmt.Errorf("failed to decrypt status metadata: %w", err)
+	}
+	var info StatusInfo
+	if err := json.Unmarshal(plaintext, &info); err != nil {
+		return nil, fmt.Errorf("invalid status metadata: %w", err)
+	}
+	return &info, nil
+}
+
+// PrintStatusInfo prints the decrypted status metadata
+func PrintStatusInfo(info StatusInfo) {
+	fmt.Printf("> Status: %s\n", info.Status)
+	if info.Time != 0 {
+		fmt.Printf("> Changed at: %s\n", time.Unix(info.Time, 0).UTC().Format(time.RFC3339))
+	}
+	if info.Reason != nil {
+		fmt.Printf("> Reason: %s\n", *info.Reason)
+	}
+}
+
+func newGCM(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
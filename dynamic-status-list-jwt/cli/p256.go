@@ -0,0 +1,120 @@
+package main
+
+import (
+	"crypto/ecdh"
+	"crypto/elliptic"
+	"errors"
+	"math/big"
+
+	"filippo.io/bigmod"
+)
+
+// Constant-time P-256 arithmetic of the ARKG derivation
+// Scalar multiplications run in crypto/ecdh, the field and scalar operations in bigmod.
+
+var (
+	p256P = mustModulus(elliptic.P256().Params().P) // field prime
+	p256N = mustModulus(elliptic.P256().Params().N) // group order
+	p256B = mustNat(elliptic.P256().Params().B, p256P)
+
+	// 2^256 mod n, reduces 48-byte scalars
+	p256R = mustNat(new(big.Int).Sub(new(big.Int).Lsh(big.NewInt(1), 256), elliptic.P256().Params().N), p256N)
+	// p - 2, the exponent of the field inversion
+	p256PMinus2 = new(big.Int).Sub(elliptic.P256().Params().P, big.NewInt(2)).Bytes()
+)
+
+func mustModulus(n *big.Int) *bigmod.Modulus {
+	m, err := bigmod.NewModulus(n.Bytes())
+	if err != nil {
+		panic(err)
+	}
+	return m
+}
+
+func mustNat(x *big.Int, m *bigmod.Modulus) *bigmod.Nat {
+	n, err := bigmod.NewNat().SetBytes(x.Bytes(), m)
+	if err != nil {
+		panic(err)
+	}
+	return n
+}
+
+// Reduce a 48-byte big-endian value mod n: hi * 2^256 + lo
+func p256ScalarReduce(b []byte) (*bigmod.Nat, error) {
+	if len(b) != 48 {
+		return nil, errors.New("the scalar must be 48 bytes long")
+	}
+	hi, err := bigmod.NewNat().SetBytes(b[:16], p256N)
+	if err != nil {
+		return nil, err
+	}
+	lo, err := bigmod.NewNat().SetOverflowingBytes(b[16:], p256N)
+	if err != nil {
+		return nil, err
+	}
+	return hi.Mul(p256R, p256N).Add(lo, p256N), nil
+}
+
+// Point in projective coordinates
+type p256Point struct{ x, y, z *bigmod.Nat }
+
+// Parse an uncompressed point of a validated ECDH public key
+func p256PointOf(pk *ecdh.PublicKey) (*p256Point, error) {
+	b := pk.Bytes()
+	x, err := bigmod.NewNat().SetBytes(b[1:33], p256P)
+	if err != nil {
+		return nil, err
+	}
+	y, err := bigmod.NewNat().SetBytes(b[33:], p256P)
+	if err != nil {
+		return nil, err
+	}
+	z, _ := bigmod.NewNat().SetBytes([]byte{1}, p256P)
+	return &p256Point{x, y, z}, nil
+}
+
+// Field operations on copies, bigmod operates in place
+func feMul(a, b *bigmod.Nat) *bigmod.Nat { return bigmod.NewNat().Mod(a, p256P).Mul(b, p256P) }
+func feAdd(a, b *bigmod.Nat) *bigmod.Nat { return bigmod.NewNat().Mod(a, p256P).Add(b, p256P) }
+func feSub(a, b *bigmod.Nat) *bigmod.Nat { return bigmod.NewNat().Mod(a, p256P).Sub(b, p256P) }
+
+// Add two points with the complete formula for a = -3
+// (Renes, Costello, Batina 2016, algorithm 4), also for equal points and the point at infinity
+func p256Add(p, q *p256Point) *p256Point {
+	t0 := feMul(p.x, q.x)
+	t1 := feMul(p.y, q.y)
+	t2 := feMul(p.z, q.z)
+	t3 := feMul(feAdd(p.x, p.y), feAdd(q.x, q.y))
+	t3 = feSub(t3, feAdd(t0, t1))
+	t4 := feMul(feAdd(p.y, p.z), feAdd(q.y, q.z))
+	t4 = feSub(t4, feAdd(t1, t2))
+	x3 := feMul(feAdd(p.x, p.z), feAdd(q.x, q.z))
+	y3 := feSub(x3, feAdd(t0, t2))
+	z3 := feMul(p256B, t2)
+	x3 = feSub(y3, z3)
+	z3 = feAdd(x3, x3)
+	x3 = feAdd(x3, z3)
+	z3 = feSub(t1, x3)
+	x3 = feAdd(t1, x3)
+	y3 = feMul(p256B, y3)
+	t1 = feAdd(t2, t2)
+	t2 = feAdd(t1, t2)
+	y3 = feSub(feSub(y3, t2), t0)
+	y3 = feAdd(feAdd(y3, y3), y3)
+	t0 = feSub(feAdd(feAdd(t0, t0), t0), t2)
+	t1 = feMul(t4, y3)
+	t2 = feMul(t0, y3)
+	y3 = feAdd(feMul(x3, z3), t2)
+	x3 = feSub(feMul(t3, x3), t1)
+	z3 = feAdd(feMul(t4, z3), feMul(t3, t0))
+	return &p256Point{x3, y3, z3}
+}
+
+// Convert to an ECDH public key
+// The point at infinity has z = 0 and is rejected by crypto/ecdh
+func (p *p256Point) publicKey() (*ecdh.PublicKey, error) {
+	zInv := bigmod.NewNat().Exp(p.z, p256PMinus2, p256P)
+	b := append([]byte{4}, feMul(p.x, zInv).Bytes(p256P)...)
+	b = append(b, feMul(p.y, zInv).Bytes(p256P)...)
+	return ecdh.P256().NewPublicKey(b)
+}
@@ -0,0 +1,105 @@
+package main
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"fmt"
+)
+
+// Padding modes of the status list
+const (
+	PaddingNone   = "none"   // one identifier per entry
+	PaddingPow2   = "pow2"   // pad to the next power of two
+	PaddingBucket = "bucket" // pad to the next multiple of the padding size
+	PaddingMin    = "min"    // pad to at least the padding size (minimum anonymity set)
+)
+
+// PaddingPolicy is published in the pad claim of the dSL JWT
+// The claim tells verifiers that the list contains decoys, not how many
+type PaddingPolicy struct {
+	Mode string `json:"mode"`
+	Size int    `json:"size,omitempty"` // bucket size or minimum number of identifiers
+}
+
+// Validate checks the padding policy
+func (p PaddingPolicy) Validate() error {
+	switch p.Mode {
+	case "", PaddingNone, PaddingPow2:
+	case PaddingBucket, PaddingMin:
+		if p.Size <= 0 {
+			return fmt.Errorf("padding mode %q requires a positive padding size", p.Mode)
+		}
+	default:
+		return fmt.Errorf("unknown padding mode %q: use %q, %q, %q or %q", p.Mode, PaddingNone, PaddingPow2, PaddingBucket, PaddingMin)
+	}
+	return nil
+}
+
+// Enabled reports whether decoys are added to the list
+func (p PaddingPolicy) Enabled() bool {
+	return p.Mode != "" && p.Mode != PaddingNone
+}
+
+// Claim returns the policy as published in the list, the size only applies to bucket and min
+func (p PaddingPolicy) Claim() PaddingPolicy {
+	if p.Mode != PaddingBucket && p.Mode != PaddingMin {
+		p.Size = 0
+	}
+	return p
+}
+
+// PaddedSize returns the number of identifiers published for n entries
+func (p PaddingPolicy) PaddedSize(n int) int {
+	switch p.Mode {
+	case PaddingPow2:
+		size := 1
+		for size < n {
+			size <<= 1
+		}
+		return size
+	case PaddingBucket:
+		if n == 0 {
+			return p.Size
+		}
+		return (n + p.Size - 1) / p.Size * p.Size
+	case PaddingMin:
+		return max(n, p.Size)
+	}
+	return n
+}
+
+// Add random decoy identifiers to the revocation list
+// Decoys have the length of a SHA-256 digest, so they cannot be told apart from the real identifiers.
+// If the list carries encrypted metadata, every decoy gets a random blob of the same length as the real ones.
+func padIdentifiers(sid []string, sme map[string]string, policy PaddingPolicy, encrypted bool) ([]string, error) {
+	decoys := policy.PaddedSize(len(sid)) - len(sid)
+	if decoys <= 0 {
+		return sid, nil
+	}
+
+	// Encrypted blobs: nonce || padded plaintext || tag
+	blobLen := 12 + metadataBlockSize + 16
+	for _, blob := range sme {
+		blobLen = base64.RawURLEncoding.DecodedLen(len(blob))
+		break
+	}
+
+	for range decoys {
+		decoy := make([]byte, sha256.Size)
+		if _, err := rand.Read(decoy); err != nil {
+			return nil, err
+		}
+		id := base64.RawURLEncoding.EncodeToString(decoy)
+		sid = append(sid, id)
+
+		if encrypted {
+			blob := make([]byte, blobLen)
+			if _, err := rand.Read(blob); err != nil {
+				return nil, err
+			}
+			sme[id] = base64.RawURLEncoding.EncodeToString(blob)
+		}
+	}
+	return sid, nil
+}
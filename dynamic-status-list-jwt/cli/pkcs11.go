@@ -0,0 +1,332 @@
+//go:build cgo
+
+package main
+
+import (
+	"crypto"
+	"crypto/ecdh"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/asn1"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"math/big"
+	"os"
+	"sync"
+
+	"github.com/lestrrat-go/jwx/v3/jwa"
+	"github.com/lestrrat-go/jwx/v3/jwk"
+	"github.com/miekg/pkcs11"
+)
+
+const pkcs11IDSize = 16 // size of the random CKA_ID of a generated key
+
+// Named curves of the EC keys (CKA_EC_PARAMS)
+var pkcs11Curves = map[string]struct {
+	curve elliptic.Curve
+	oid   asn1.ObjectIdentifier
+}{
+	"ES256": {elliptic.P256(), asn1.ObjectIdentifier{1, 2, 840, 10045, 3, 1, 7}},
+	"ES384": {elliptic.P384(), asn1.ObjectIdentifier{1, 3, 132, 0, 34}},
+	"ES512": {elliptic.P521(), asn1.ObjectIdentifier{1, 3, 132, 0, 35}},
+}
+
+// DigestInfo prefix of the RSA PKCS #1 v1.5 signatures (RFC 8017, section 9.2)
+var pkcs11SHA256Prefix = []byte{0x30, 0x31, 0x30, 0x0d, 0x06, 0x09, 0x60, 0x86, 0x48, 0x01, 0x65, 0x03, 0x04, 0x02, 0x01, 0x05, 0x00, 0x04, 0x20}
+
+// PKCS#11 backend: the private keys are generated in the token and never leave it
+// The key ring stores the public JWK and the CKA_ID of each token key
+type pkcs11Backend struct {
+	ctx     *pkcs11.Ctx
+	session pkcs11.SessionHandle
+	mu      sync.Mutex // a session runs one operation at a time
+}
+
+// Open a session on the token and log in with the PIN of the environment
+func openPKCS11Backend(config *PKCS11Config) (KeyBackend, error) {
+	if err := config.Validate(); err != nil {
+		return nil, err
+	}
+	pin := os.Getenv(pkcs11PinEnv)
+	if pin == "" {
+		return nil, fmt.Errorf("set the user PIN of the token in %s", pkcs11PinEnv)
+	}
+
+	ctx := pkcs11.New(config.Module)
+	if ctx == nil {
+		return nil, fmt.Errorf("failed to load the PKCS#11 module %s", config.Module)
+	}
+	if err := ctx.Initialize(); err != nil && !errors.Is(err, pkcs11.Error(pkcs11.CKR_CRYPTOKI_ALREADY_INITIALIZED)) {
+		return nil, fmt.Errorf("failed to initialize the PKCS#11 module: %w", err)
+	}
+
+	// Find the token by label
+	slots, err := ctx.GetSlotList(true)
+	if err != nil {
+		return nil, err
+	}
+	for _, slot := range slots {
+		info, err := ctx.GetTokenInfo(slot)
+		if err != nil || info.Label != config.Token {
+			continue
+		}
+		session, err := ctx.OpenSession(slot, pkcs11.CKF_SERIAL_SESSION|pkcs11.CKF_RW_SESSION)
+		if err != nil {
+			return nil, fmt.Errorf("failed to open a session on the token %q: %w", config.Token, err)
+		}
+		if err := ctx.Login(session, pkcs11.CKU_USER, pin); err != nil && !errors.Is(err, pkcs11.Error(pkcs11.CKR_USER_ALREADY_LOGGED_IN)) {
+			return nil, fmt.Errorf("failed to log in to the token %q: %w", config.Token, err)
+		}
+		return &pkcs11Backend{ctx: ctx, session: session}, nil
+	}
+	return nil, fmt.Errorf("PKCS#11 token %q not found", config.Token)
+}
+
+// Generate a key pair in the token
+// The private key is sensitive and not extractable, it signs and derives the ECDH secrets
+func (b *pkcs11Backend) Generate(alg jwa.SignatureAlgorithm, activates int64) (*IssuerKey, error) {
+	id := make([]byte, pkcs11IDSize)
+	if _, err := rand.Read(id); err != nil {
+		return nil, err
+	}
+	common := []*pkcs11.Attribute{
+		pkcs11.NewAttribute(pkcs11.CKA_TOKEN, true),
+		pkcs11.NewAttribute(pkcs11.CKA_ID, id),
+		pkcs11.NewAttribute(pkcs11.CKA_LABEL, "dsl issuer key"),
+	}
+	private := append([]*pkcs11.Attribute{
+		pkcs11.NewAttribute(pkcs11.CKA_PRIVATE, true),
+		pkcs11.NewAttribute(pkcs11.CKA_SENSITIVE, true),
+		pkcs11.NewAttribute(pkcs11.CKA_EXTRACTABLE, false),
+		pkcs11.NewAttribute(pkcs11.CKA_SIGN, true),
+	}, common...)
+	public := append([]*pkcs11.Attribute{
+		pkcs11.NewAttribute(pkcs11.CKA_VERIFY, true),
+	}, common...)
+
+	var mechanism uint
+	if c, ok := pkcs11Curves[alg.String()]; ok {
+		params, err := asn1.Marshal(c.oid)
+		if err != nil {
+			return nil, err
+		}
+		mechanism = pkcs11.CKM_EC_KEY_PAIR_GEN
+		public = append(public, pkcs11.NewAttribute(pkcs11.CKA_EC_PARAMS, params))
+		private = append(private, pkcs11.NewAttribute(pkcs11.CKA_DERIVE, true))
+	} else if alg == jwa.RS256() {
+		mechanism = pkcs11.CKM_RSA_PKCS_KEY_PAIR_GEN
+		public = append(public,
+			pkcs11.NewAttribute(pkcs11.CKA_MODULUS_BITS, rsaKeySize),
+			pkcs11.NewAttribute(pkcs11.CKA_PUBLIC_EXPONENT, []byte{1, 0, 1}),
+		)
+	} else {
+		return nil, fmt.Errorf("the PKCS#11 backend does not support %s keys", alg)
+	}
+
+	b.mu.Lock()
+	pubHandle, privHandle, err := b.ctx.GenerateKeyPair(b.session, []*pkcs11.Mechanism{pkcs11.NewMechanism(mechanism, nil)}, public, private)
+	b.mu.Unlock()
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate the key in the token: %w", err)
+	}
+	pub, err := b.publicKey(pubHandle, alg)
+	if err != nil {
+		return nil, err
+	}
+
+	// Store the public JWK, the private key stays in the token
+	jwkKey, err := jwk.Import(pub)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create JWK from public key: %w", err)
+	}
+	if err := jwkKey.Set(jwk.AlgorithmKey, alg); err != nil {
+		return nil, err
+	}
+	k, err := NewIssuerKey(jwkKey, hex.EncodeToString(id), activates)
+	if err != nil {
+		return nil, err
+	}
+
+	// Label the objects with the kid, to find them with the token tools
+	b.mu.Lock()
+	for _, handle := range []pkcs11.ObjectHandle{pubHandle, privHandle} {
+		err = b.ctx.SetAttributeValue(b.session, handle, []*pkcs11.Attribute{pkcs11.NewAttribute(pkcs11.CKA_LABEL, k.Kid)})
+		if err != nil {
+			break
+		}
+	}
+	b.mu.Unlock()
+	if err != nil {
+		return nil, fmt.Errorf("failed to label the token key: %w", err)
+	}
+	k.signer = &pkcs11Signer{backend: b, handle: privHandle, pub: pub}
+	return k, nil
+}
+
+// Read the public key of a generated key pair
+func (b *pkcs11Backend) publicKey(handle pkcs11.ObjectHandle, alg jwa.SignatureAlgorithm) (crypto.PublicKey, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if c, ok := pkcs11Curves[alg.String()]; ok {
+		attrs, err := b.ctx.GetAttributeValue(b.session, handle, []*pkcs11.Attribute{pkcs11.NewAttribute(pkcs11.CKA_EC_POINT, nil)})
+		if err != nil {
+			return nil, err
+		}
+		// The point is a DER encoded octet string
+		var point []byte
+		if _, err := asn1.Unmarshal(attrs[0].Value, &point); err != nil {
+			return nil, fmt.Errorf("invalid EC point of the token key: %w", err)
+		}
+		x, y := elliptic.Unmarshal(c.curve, point)
+		if x == nil {
+			return nil, errors.New("invalid EC point of the token key")
+		}
+		return &ecdsa.PublicKey{Curve: c.curve, X: x, Y: y}, nil
+	}
+
+	attrs, err := b.ctx.GetAttributeValue(b.session, handle, []*pkcs11.Attribute{
+		pkcs11.NewAttribute(pkcs11.CKA_MODULUS, nil),
+		pkcs11.NewAttribute(pkcs11.CKA_PUBLIC_EXPONENT, nil),
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &rsa.PublicKey{
+		N: new(big.Int).SetBytes(attrs[0].Value),
+		E: int(new(big.Int).SetBytes(attrs[1].Value).Int64()),
+	}, nil
+}
+
+// Attach the signers of the token keys, found by their CKA_ID
+func (b *pkcs11Backend) Attach(ring *KeyRing) error {
+	for _, k := range ring.Keys {
+		if k.Token == "" {
+			continue
+		}
+		id, err := hex.DecodeString(k.Token)
+		if err != nil {
+			return fmt.Errorf("invalid token id of the issuer key %s: %w", k.Kid, err)
+		}
+		handle, err := b.findPrivateKey(id)
+		if err != nil {
+			return fmt.Errorf("issuer key %s: %w", k.Kid, err)
+		}
+		var pub interface{}
+		if err := jwk.Export(k.pub, &pub); err != nil {
+			return err
+		}
+		k.signer = &pkcs11Signer{backend: b, handle: handle, pub: pub}
+	}
+	return nil
+}
+
+// Find the private key object with the given CKA_ID
+func (b *pkcs11Backend) findPrivateKey(id []byte) (pkcs11.ObjectHandle, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	template := []*pkcs11.Attribute{
+		pkcs11.NewAttribute(pkcs11.CKA_CLASS, pkcs11.CKO_PRIVATE_KEY),
+		pkcs11.NewAttribute(pkcs11.CKA_ID, id),
+	}
+	if err := b.ctx.FindObjectsInit(b.session, template); err != nil {
+		return 0, err
+	}
+	handles, _, err := b.ctx.FindObjects(b.session, 1)
+	if finalErr := b.ctx.FindObjectsFinal(b.session); err == nil {
+		err = finalErr
+	}
+	if err != nil {
+		return 0, err
+	}
+	if len(handles) == 0 {
+		return 0, errors.New("private key not found in the token")
+	}
+	return handles[0], nil
+}
+
+// Signer of a private key in the token
+type pkcs11Signer struct {
+	backend *pkcs11Backend
+	handle  pkcs11.ObjectHandle
+	pub     crypto.PublicKey
+}
+
+func (s *pkcs11Signer) Public() crypto.PublicKey {
+	return s.pub
+}
+
+func (s *pkcs11Signer) Sign(_ io.Reader, digest []byte, opts crypto.SignerOpts) ([]byte, error) {
+	b := s.backend
+	switch s.pub.(type) {
+	case *ecdsa.PublicKey:
+		b.mu.Lock()
+		sig, err := b.sign(pkcs11.CKM_ECDSA, s.handle, digest)
+		b.mu.Unlock()
+		if err != nil {
+			return nil, err
+		}
+		// The token returns r || s, crypto.Signer returns the ASN.1 encoding
+		n := len(sig) / 2
+		return asn1.Marshal(struct{ R, S *big.Int }{
+			new(big.Int).SetBytes(sig[:n]),
+			new(big.Int).SetBytes(sig[n:]),
+		})
+	case *rsa.PublicKey:
+		if opts.HashFunc() != crypto.SHA256 {
+			return nil, fmt.Errorf("the PKCS#11 backend does not sign %s digests", opts.HashFunc())
+		}
+		b.mu.Lock()
+		defer b.mu.Unlock()
+		return b.sign(pkcs11.CKM_RSA_PKCS, s.handle, append(append([]byte{}, pkcs11SHA256Prefix...), digest...))
+	}
+	return nil, fmt.Errorf("unsupported token key %T", s.pub)
+}
+
+// Sign data with a mechanism, the caller holds the session lock
+func (b *pkcs11Backend) sign(mechanism uint, handle pkcs11.ObjectHandle, data []byte) ([]byte, error) {
+	if err := b.ctx.SignInit(b.session, []*pkcs11.Mechanism{pkcs11.NewMechanism(mechanism, nil)}, handle); err != nil {
+		return nil, fmt.Errorf("failed to sign with the token: %w", err)
+	}
+	sig, err := b.ctx.Sign(b.session, data)
+	if err != nil {
+		return nil, fmt.Errorf("failed to sign with the token: %w", err)
+	}
+	return sig, nil
+}
+
+// ECDH in the token (CKM_ECDH1_DERIVE): the shared secret is derived as a session object and read back
+func (s *pkcs11Signer) ECDH(pub *ecdh.PublicKey) ([]byte, error) {
+	if _, ok := s.pub.(*ecdsa.PublicKey); !ok {
+		return nil, errors.New("ECDH requires an EC issuer key")
+	}
+	b := s.backend
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	params := pkcs11.NewECDH1DeriveParams(pkcs11.CKD_NULL, nil, pub.Bytes())
+	template := []*pkcs11.Attribute{
+		pkcs11.NewAttribute(pkcs11.CKA_CLASS, pkcs11.CKO_SECRET_KEY),
+		pkcs11.NewAttribute(pkcs11.CKA_KEY_TYPE, pkcs11.CKK_GENERIC_SECRET),
+		pkcs11.NewAttribute(pkcs11.CKA_TOKEN, false),
+		pkcs11.NewAttribute(pkcs11.CKA_SENSITIVE, false),
+		pkcs11.NewAttribute(pkcs11.CKA_EXTRACTABLE, true),
+	}
+	secret, err := b.ctx.DeriveKey(b.session, []*pkcs11.Mechanism{pkcs11.NewMechanism(pkcs11.CKM_ECDH1_DERIVE, params)}, s.handle, template)
+	if err != nil {
+		return nil, fmt.Errorf("failed to derive the ECDH secret in the token: %w", err)
+	}
+	defer b.ctx.DestroyObject(b.session, secret)
+	attrs, err := b.ctx.GetAttributeValue(b.session, secret, []*pkcs11.Attribute{pkcs11.NewAttribute(pkcs11.CKA_VALUE, nil)})
+	if err != nil {
+		return nil, err
+	}
+	return attrs[0].Value, nil
+}
+
+// The legacy seeds hash the raw private key, a token key never reveals it
+func (s *pkcs11Signer) LegacySecret() ([]byte, error) {
+	return nil, ErrLegacySecretMissing
+}
@@ -0,0 +1,10 @@
+//go:build !cgo
+
+package main
+
+import "errors"
+
+// The PKCS#11 modules are C libraries, the backend needs cgo
+func openPKCS11Backend(config *PKCS11Config) (KeyBackend, error) {
+	return nil, errors.New("the pkcs11 key backend requires a build with cgo")
+}
@@ -0,0 +1,125 @@
+//go:build cgo
+
+package main
+
+import (
+	"bytes"
+	"crypto/ecdh"
+	"crypto/ecdsa"
+	"crypto/rand"
+	"encoding/json"
+	"errors"
+	"os"
+	"testing"
+
+	"github.com/lestrrat-go/jwx/v3/jwa"
+	"github.com/lestrrat-go/jwx/v3/jwk"
+	"github.com/lestrrat-go/jwx/v3/jwt"
+)
+
+// Token of the SoftHSM tests, the tests are skipped unless SOFTHSM2_CONF is set
+func openTestPKCS11Backend(t *testing.T) KeyBackend {
+	t.Helper()
+	if os.Getenv("SOFTHSM2_CONF") == "" {
+		t.Skip("SOFTHSM2_CONF is not set")
+	}
+	config := &PKCS11Config{Module: os.Getenv("DSL_PKCS11_MODULE"), Token: os.Getenv("DSL_PKCS11_TOKEN")}
+	if config.Module == "" {
+		config.Module = "/usr/lib/softhsm/libsofthsm2.so"
+	}
+	if config.Token == "" {
+		config.Token = "dsl-test"
+	}
+	backend, err := openPKCS11Backend(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return backend
+}
+
+func TestPKCS11Sign(t *testing.T) {
+	backend := openTestPKCS11Backend(t)
+	for _, alg := range []jwa.SignatureAlgorithm{jwa.ES256(), jwa.ES384(), jwa.RS256()} {
+		t.Run(alg.String(), func(t *testing.T) {
+			key, err := backend.Generate(alg, 0)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if key.Backend() != KeyBackendPKCS11 {
+				t.Fatalf("got backend %s, want %s", key.Backend(), KeyBackendPKCS11)
+			}
+
+			// The stored key holds the public JWK only, the signer is found again by its CKA_ID
+			data, err := json.Marshal(&KeyRing{Keys: []*IssuerKey{key}})
+			if err != nil {
+				t.Fatal(err)
+			}
+			ring, _, err := decodeKeyRing(data)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var stored map[string]interface{}
+			if err := json.Unmarshal(ring.Keys[0].JWK, &stored); err != nil {
+				t.Fatal(err)
+			}
+			if _, ok := stored["d"]; ok {
+				t.Fatal("the key ring stores the private key of a token key")
+			}
+			if err := backend.Attach(ring); err != nil {
+				t.Fatal(err)
+			}
+
+			tok := jwt.New()
+			tok.Set(jwt.JwtIDKey, "4e1a7c0b2d9f4c35a1e8b6d0f3c2a917")
+			signed, err := signJWTWith(tok, ring.Keys[0])
+			if err != nil {
+				t.Fatal(err)
+			}
+			set := jwk.NewSet()
+			if err := set.AddKey(key.pub); err != nil {
+				t.Fatal(err)
+			}
+			if _, err := verifyJWT(signed, set, []string{alg.String()}); err != nil {
+				t.Fatal(err)
+			}
+		})
+	}
+}
+
+func TestPKCS11ECDH(t *testing.T) {
+	backend := openTestPKCS11Backend(t)
+	key, err := backend.Generate(jwa.ES256(), 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	signer, err := key.Signer()
+	if err != nil {
+		t.Fatal(err)
+	}
+	holder, err := ecdh.P256().GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The token and the holder derive the same secret
+	shared, err := signer.ECDH(holder.PublicKey())
+	if err != nil {
+		t.Fatal(err)
+	}
+	issuerPub, err := signer.Public().(*ecdsa.PublicKey).ECDH()
+	if err != nil {
+		t.Fatal(err)
+	}
+	want, err := holder.ECDH(issuerPub)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(shared, want) {
+		t.Fatal("the token and the holder derived different ECDH secrets")
+	}
+
+	// The raw private key never leaves the token
+	if _, err := signer.LegacySecret(); !errors.Is(err, ErrLegacySecretMissing) {
+		t.Fatalf("got %v, want %v", err, ErrLegacySecretMissing)
+	}
+}
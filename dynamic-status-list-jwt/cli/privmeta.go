@@ -0,0 +1,85 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/lestrrat-go/jwx/v3/jwa"
+	"github.com/lestrrat-go/jwx/v3/jwe"
+	"github.com/lestrrat-go/jwx/v3/jwk"
+	"github.com/lestrrat-go/jwx/v3/jwt"
+)
+
+// Recipient of the private metadata: the registered holder master key, or the cnf key of the credential
+func privateMetadataRecipient(idt jwt.Token, masterKeyPath string) (jwk.Key, error) {
+	if masterKeyPath != "" {
+		key, err := LoadJWK(masterKeyPath)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load the holder master key: %w", err)
+		}
+		return key.PublicKey()
+	}
+	key, ok, err := CredentialKey(idt)
+	if err != nil || !ok {
+		return nil, err
+	}
+	return key, nil
+}
+
+// Key management algorithm for the holder's key type
+func keyEncryptionAlgorithm(key jwk.Key) (jwa.KeyEncryptionAlgorithm, error) {
+	switch key.KeyType() {
+	case jwa.EC():
+		return jwa.ECDH_ES_A256KW(), nil
+	case jwa.RSA():
+		return jwa.RSA_OAEP_256(), nil
+	}
+	return jwa.KeyEncryptionAlgorithm{}, fmt.Errorf("cannot encrypt to a %s key", key.KeyType())
+}
+
+// Encrypt the signed private metadata to the holder (nested JWT)
+// Only the holder's private key can decrypt the seed
+func encryptPrivateMetadata(signed []byte, recipient jwk.Key) ([]byte, error) {
+	alg, err := keyEncryptionAlgorithm(recipient)
+	if err != nil {
+		return nil, err
+	}
+	h := jwe.NewHeaders()
+	h.Set(jwe.ContentTypeKey, "JWT")
+	return jwe.Encrypt(signed,
+		jwe.WithKey(alg, recipient),
+		jwe.WithContentEncryption(jwa.A256GCM()),
+		jwe.WithProtectedHeaders(h),
+	)
+}
+
+// Decrypt the private metadata with the holder's private key
+// Private metadata issued before encryption was supported, or explicitly in plaintext, is a plain JWT
+func decryptPrivateMetadata(raw string, holderKeyPath string, allowPlaintext bool) ([]byte, error) {
+	// A JWE in compact serialization has five parts, a JWS three
+	switch strings.Count(raw, ".") {
+	case 4:
+	case 2:
+		if !allowPlaintext {
+			return nil, errors.New("the private metadata is not encrypted, anyone who saw it can derive the identifiers: pass --allow-plaintext-metadata to use it")
+		}
+		return []byte(raw), nil
+	default:
+		return nil, errors.New("the private metadata is malformed")
+	}
+
+	key, err := LoadJWK(holderKeyPath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load the holder key: %w", err)
+	}
+	alg, err := keyEncryptionAlgorithm(key)
+	if err != nil {
+		return nil, err
+	}
+	signed, err := jwe.Decrypt([]byte(raw), jwe.WithKey(alg, key))
+	if err != nil {
+		return nil, errors.New("failed to decrypt the private metadata, is it encrypted to another holder key?")
+	}
+	return signed, nil
+}
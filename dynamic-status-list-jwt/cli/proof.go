@@ -0,0 +1,243 @@
+package main
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"slices"
+	"time"
+
+	"github.com/lestrrat-go/jwx/v3/jwk"
+	"github.com/lestrrat-go/jwx/v3/jws"
+	"github.com/lestrrat-go/jwx/v3/jwt"
+)
+
+const (
+	holderKeyFile      = "holder-key.json" // holder's proof-of-possession key
+	proofType          = "dsl-proof+jwt"   // typ header of a signed holder proof
+	defaultProofMaxAge = 5 * time.Minute   // oldest accepted holder proof
+)
+
+// Reasons a holder proof is rejected
+var (
+	ErrProofUnsigned  = errors.New("holder proof is not signed")
+	ErrProofSignature = errors.New("holder proof signature is invalid")
+	ErrProofAudience  = errors.New("holder proof is intended for another verifier")
+	ErrProofNonce     = errors.New("holder proof nonce does not match")
+	ErrProofStale     = errors.New("holder proof is not fresh")
+	ErrProofUnbound   = errors.New("the expected audience and nonce are required to check a signed holder proof")
+	ErrProofIssuer    = errors.New("the credential and the status list have different issuers")
+)
+
+// ProofOptions configure the holder proof
+type ProofOptions struct {
+	HolderKey string // path to the holder's private JWK
+	MasterKey string // path to the holder's private master JWK, if the private metadata is encrypted to it
+	Audience  string // verifier the proof is intended for
+	Nonce     string // nonce provided by the verifier
+
+	AllowPlaintextMetadata bool // accept private metadata that is not encrypted to the holder
+}
+
+// Key the private metadata is decrypted with
+func (o ProofOptions) decryptionKey() string {
+	if o.MasterKey != "" {
+		return o.MasterKey
+	}
+	return o.HolderKey
+}
+
+// LoadOrCreateHolderKey loads the holder's private key or generates a new P-256 key
+func LoadOrCreateHolderKey(path string) (jwk.Key, error) {
+	key, err := LoadJWK(path)
+	if err == nil {
+		return key, nil
+	}
+	if !errors.Is(err, os.ErrNotExist) {
+		return nil, err
+	}
+
+	fmt.Printf("> Generating a new holder key (%s)\n", path)
+	sk, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate the holder key: %w", err)
+	}
+	key, err = jwk.Import(sk)
+	if err != nil {
+		return nil, err
+	}
+	if err := SaveJSON(key, path); err != nil {
+		return nil, err
+	}
+	return key, nil
+}
+
+// CredentialKey returns the proof-of-possession key (cnf.jwk) of a credential
+// ok is false if the credential is not bound to a key
+func CredentialKey(credential jwt.Token) (jwk.Key, bool, error) {
+	if !credential.Has("cnf") {
+		return nil, false, nil
+	}
+	var cnf map[string]interface{}
+	if err := credential.Get("cnf", &cnf); err != nil {
+		return nil, false, err
+	}
+	raw, ok := cnf["jwk"]
+	if !ok {
+		return nil, false, errors.New("cnf claim has no jwk")
+	}
+	data, err := json.Marshal(raw)
+	if err != nil {
+		return nil, false, err
+	}
+	key, err := jwk.ParseKey(data)
+	if err != nil {
+		return nil, false, fmt.Errorf("invalid cnf key: %w", err)
+	}
+	return key, true, nil
+}
+
+// Sign the holder's proof with the proof-of-possession key
+// The proof carries the verifier's audience and nonce, so it cannot be replayed to other verifiers
+func signHolderProof(key jwk.Key, p HolderProofPayload) (string, error) {
+	t := jwt.New()
+	t.Set(jwt.JwtIDKey, p.Jti)
+	t.Set(jwt.IssuedAtKey, p.Iat)
+	t.Set("token", p.Token)
+	t.Set("sid", p.Sid)
+	if p.Aud != "" {
+		t.Set(jwt.AudienceKey, p.Aud)
+	}
+	if p.Nonce != "" {
+		t.Set("nonce", p.Nonce)
+	}
+
+	alg, err := keyAlgorithm(key)
+	if err != nil {
+		return "", err
+	}
+	h := jws.NewHeaders()
+	h.Set(jws.TypeKey, proofType)
+	signed, err := jwt.Sign(t, jwt.WithKey(alg, key, jws.WithProtectedHeaders(h)))
+	if err != nil {
+		return "", err
+	}
+	return string(signed), nil
+}
+
+// LoadHolderProof loads the holder's proof and verifies it against the credential
+// A signed proof must be signed by the credential's cnf key, be fresh and match the
+// verifier's audience and nonce. Unsigned proofs are rejected unless allowed.
+// The credential's issuer is kept, the status list must have the same issuer.
+func LoadHolderProof(path string, opts VerifyOptions) (HolderProofPayload, error) {
+	var h HolderProofPayload
+	err := LoadJSON(&h, path)
+	if err != nil {
+		return h, err
+	}
+	if h.ProofJwt == "" {
+		if !opts.AllowUnsignedProof {
+			return h, ErrProofUnsigned
+		}
+		return h, nil
+	}
+	if !opts.AllowUnboundProof && (opts.Audience == "" || opts.Nonce == "") {
+		return h, ErrProofUnbound
+	}
+
+	// The proof-of-possession key is taken from the issuer signed credential,
+	// the one given to the verifier or the one presented with the proof
+	raw := h.Credential
+	if opts.Credential != "" {
+		var jwtData JWTData
+		err = LoadJSON(&jwtData, opts.Credential)
+		if err != nil {
+			return h, err
+		}
+		raw = jwtData.Jwt
+	}
+	if raw == "" {
+		return h, errors.New("the credential is required to verify a signed holder proof")
+	}
+	credential, err := parseTrustedJWT([]byte(raw), opts)
+	if err != nil {
+		return h, fmt.Errorf("credential: %w", err)
+	}
+	key, ok, err := CredentialKey(credential)
+	if err != nil {
+		return h, err
+	}
+	if !ok {
+		return h, errors.New("the credential is not bound to a holder key (cnf)")
+	}
+
+	// Verify the signature and the typ header
+	set := jwk.NewSet()
+	if err := set.AddKey(key); err != nil {
+		return h, err
+	}
+	msg, err := jws.Parse([]byte(h.ProofJwt))
+	if err != nil || len(msg.Signatures()) != 1 {
+		return h, fmt.Errorf("%w: malformed proof", ErrProofSignature)
+	}
+	if typ, _ := msg.Signatures()[0].ProtectedHeaders().Type(); typ != proofType {
+		return h, fmt.Errorf("%w: unexpected typ %q", ErrProofSignature, typ)
+	}
+	t, err := verifyJWT([]byte(h.ProofJwt), set, opts.allowedAlgorithms())
+	if err != nil {
+		return h, fmt.Errorf("%w: %w", ErrProofSignature, err)
+	}
+
+	// The claims of the signed proof replace the plain ones
+	p := HolderProofPayload{ProofJwt: h.ProofJwt, Credential: raw}
+	if err := credential.Get(jwt.IssuerKey, &p.issuer); err != nil || p.issuer == "" {
+		return h, errors.New("credential: iss claim missing")
+	}
+	if err := t.Get(jwt.JwtIDKey, &p.Jti); err != nil {
+		return h, fmt.Errorf("%w: jti claim missing", ErrProofSignature)
+	}
+	if err := t.Get("token", &p.Token); err != nil {
+		return h, fmt.Errorf("%w: token claim missing", ErrProofSignature)
+	}
+	t.Get("sid", &p.Sid)
+	var credentialJti string
+	if err := credential.Get(jwt.JwtIDKey, &credentialJti); err != nil || credentialJti != p.Jti {
+		return h, fmt.Errorf("%w: the proof is for another credential", ErrProofSignature)
+	}
+
+	// Freshness
+	var iat time.Time
+	if err := t.Get(jwt.IssuedAtKey, &iat); err != nil {
+		return h, fmt.Errorf("%w: iat claim missing", ErrProofStale)
+	}
+	p.Iat = iat.Unix()
+	now := opts.Now
+	if now.IsZero() {
+		now = time.Now()
+	}
+	maxAge := opts.MaxProofAge
+	if maxAge == 0 {
+		maxAge = defaultProofMaxAge
+	}
+	if iat.After(now.Add(opts.ClockSkew)) || iat.Before(now.Add(-maxAge-opts.ClockSkew)) {
+		return h, fmt.Errorf("%w: iat is %d", ErrProofStale, p.Iat)
+	}
+
+	// Audience and nonce, a proof without them does not match the expected ones
+	aud, _ := t.Audience()
+	if opts.Audience != "" && !slices.Contains(aud, opts.Audience) {
+		return h, fmt.Errorf("%w: aud is %v", ErrProofAudience, aud)
+	}
+	if len(aud) > 0 {
+		p.Aud = aud[0]
+	}
+	t.Get("nonce", &p.Nonce)
+	if opts.Nonce != "" && p.Nonce != opts.Nonce {
+		return h, ErrProofNonce
+	}
+	return p, nil
+}
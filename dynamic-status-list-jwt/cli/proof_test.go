@@ -0,0 +1,157 @@
+package main
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/lestrrat-go/jwx/v3/jwk"
+	"github.com/lestrrat-go/jwx/v3/jwt"
+)
+
+func newTestHolderKey(t *testing.T) jwk.Key {
+	t.Helper()
+	sk, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	key, err := jwk.Import(sk)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return key
+}
+
+// Credential signed by the issuer, bound to the holder key
+func newTestCredential(t *testing.T, s *Server, jti string, holderKey jwk.Key) string {
+	t.Helper()
+	holderPK, err := holderKey.PublicKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	key, err := s.signingKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	iss, err := s.issuer()
+	if err != nil {
+		t.Fatal(err)
+	}
+	tok := jwt.New()
+	tok.Set(jwt.IssuerKey, iss)
+	tok.Set(jwt.JwtIDKey, jti)
+	tok.Set("cnf", map[string]interface{}{"jwk": holderPK})
+	signed, err := signJWTWith(tok, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(signed)
+}
+
+// Signed proof of the entry's current token, presented with the credential
+func writeTestProof(t *testing.T, s *Server, jti string, credential string, holderKey jwk.Key, aud string, nonce string) string {
+	t.Helper()
+	secret, err := s.entrySeed(jti, (*s.Dsl)[jti])
+	if err != nil {
+		t.Fatal(err)
+	}
+	iat := time.Now().Unix()
+	token, err := NewToken(secret.seed[:], iat, s.Config.Period)
+	if err != nil {
+		t.Fatal(err)
+	}
+	p := HolderProofPayload{Jti: jti, Token: base64.RawURLEncoding.EncodeToString(token), Iat: iat, Aud: aud, Nonce: nonce}
+	p.ProofJwt, err = signHolderProof(holderKey, p)
+	if err != nil {
+		t.Fatal(err)
+	}
+	p.Credential = credential
+	path := filepath.Join(t.TempDir(), "proof.json")
+	if err := SaveJSON(p, path); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+// Issuer with a single revoked entry, and its published windows
+func newTestIssuerWithEntry(t *testing.T) (*Server, string, *Verifier) {
+	t.Helper()
+	s := newTestServer(t)
+	jti := "4e1a7c0b2d9f4c35a1e8b6d0f3c2a917"
+	(*s.Dsl)[jti] = DslEntry{Status: StatusRevoked, SeedVersion: currentSeedVersion}
+	publishTestWindows(t, s)
+	v := NewVerifier(VerifyOptions{})
+	for _, w := range s.DslWindows {
+		if _, err := v.Load([]byte(w.DslJwt)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return s, jti, v
+}
+
+func TestProofCredentialIssuer(t *testing.T) {
+	s, jti, v := newTestIssuerWithEntry(t)
+	opts := VerifyOptions{Audience: "https://verifier.example", Nonce: "8f2a1c"}
+
+	// The credential of the list's issuer
+	holderKey := newTestHolderKey(t)
+	path := writeTestProof(t, s, jti, newTestCredential(t, s, jti, holderKey), holderKey, opts.Audience, opts.Nonce)
+	h, err := LoadHolderProof(path, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	result, err := v.Check(h)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.Status != StatusRevoked {
+		t.Fatalf("got status %s, want %s", result.Status, StatusRevoked)
+	}
+
+	// A credential with the same jti, self-signed by another issuer
+	other := newTestServer(t)
+	forgedKey := newTestHolderKey(t)
+	path = writeTestProof(t, s, jti, newTestCredential(t, other, jti, forgedKey), forgedKey, opts.Audience, opts.Nonce)
+	h, err = LoadHolderProof(path, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := v.Check(h); !errors.Is(err, ErrProofIssuer) {
+		t.Fatalf("got %v, want %v", err, ErrProofIssuer)
+	}
+}
+
+func TestProofAudienceAndNonce(t *testing.T) {
+	s, jti, _ := newTestIssuerWithEntry(t)
+	holderKey := newTestHolderKey(t)
+	credential := newTestCredential(t, s, jti, holderKey)
+	bound := writeTestProof(t, s, jti, credential, holderKey, "https://verifier.example", "8f2a1c")
+	unbound := writeTestProof(t, s, jti, credential, holderKey, "", "")
+
+	for _, tc := range []struct {
+		name string
+		path string
+		opts VerifyOptions
+		err  error
+	}{
+		{"bound", bound, VerifyOptions{Audience: "https://verifier.example", Nonce: "8f2a1c"}, nil},
+		{"no expected audience and nonce", bound, VerifyOptions{}, ErrProofUnbound},
+		{"no expected nonce", bound, VerifyOptions{Audience: "https://verifier.example"}, ErrProofUnbound},
+		{"other audience", bound, VerifyOptions{Audience: "https://other.example", Nonce: "8f2a1c"}, ErrProofAudience},
+		{"other nonce", bound, VerifyOptions{Audience: "https://verifier.example", Nonce: "0000"}, ErrProofNonce},
+		{"proof without audience", unbound, VerifyOptions{Audience: "https://verifier.example", Nonce: "8f2a1c"}, ErrProofAudience},
+		{"unbound proofs allowed", unbound, VerifyOptions{AllowUnboundProof: true}, nil},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			_, err := LoadHolderProof(tc.path, tc.opts)
+			if !errors.Is(err, tc.err) {
+				t.Fatalf("got %v, want %v", err, tc.err)
+			}
+		})
+	}
+}
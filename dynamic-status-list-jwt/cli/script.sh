@@ -9,6 +9,11 @@ then
     exit 1
 fi
 
+# Verifier the proof is presented to, and the nonce it provided
+# A real verifier sends a fresh nonce for every presentation
+verifier_aud="${DSL_VERIFIER_AUD:-https://verifier.example}"
+verifier_nonce="${DSL_VERIFIER_NONCE:-8f2a1c}"
+
 # Clone repository and navigate to CLI directory
 clone_repo() {
     git clone git@github.com:MyNextID/idt-plus-plus.git
@@ -41,7 +46,7 @@ create_status_list_entry() {
 compute_revocation_identifier() {
     local input_file="${1:-mock-jwt.json}" # Default to 'mock-jwt.json' if no input is provided
     local timestamp="${2:-$(date +%s)}"    # Default to current timestamp if no timestamp is provided
-    ./dsl wallet -i "$input_file" -t "$timestamp"
+    ./dsl wallet -i "$input_file" -t "$timestamp" --aud "$verifier_aud" --nonce "$verifier_nonce"
 }
 
 # Recompute the dynamic status list
@@ -60,7 +65,8 @@ revoke_jwt() {
 verify_revocation_status() {
     local status_list_file="${1:-dsl.json}"  # Default to 'dsl.json' if no input is provided
     local holder_file="${2:-holder_status-list-identifier.json}" # Default to 'holder_status-list-identifier.json'
-    ./dsl verify -s "$status_list_file" -p "$holder_file"
+    local credential_file="${3:-mock-jwt.json}" # Default to 'mock-jwt.json', its cnf key verifies the proof
+    ./dsl verify -s "$status_list_file" -p "$holder_file" -c "$credential_file" --aud "$verifier_aud" --nonce "$verifier_nonce"
 }
 
 # Create detached status list metadata
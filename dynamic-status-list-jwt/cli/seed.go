@@ -0,0 +1,106 @@
+package main
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+
+	"golang.org/x/crypto/hkdf"
+)
+
+const seedMasterFile = "seed-master.json" // seed master secret of the file store
+
+// Seed derivations, stored with each entry
+const (
+	// seed = H(sha256.New().Sum(sk.D), H(jti)): the secret is the raw signing key
+	// Entries registered before seed versioning have no version and use it
+	SeedVersionLegacy = 1
+	// seed = HKDF-Expand(PRK, "dsl/v2 entry seed" || H(jti)), PRK = HKDF-Extract("dsl/v2 seed master", master)
+	// The seed master is a random secret independent of the signing key
+	SeedVersionHKDF = 2
+
+	currentSeedVersion = SeedVersionHKDF
+)
+
+// HKDF labels of the v2 seed derivation
+const (
+	seedMasterLabel = "dsl/v2 seed master" // HKDF salt of the seed master
+	seedEntryLabel  = "dsl/v2 entry seed"  // HKDF info prefix of the entry seeds
+	seedMasterSize  = 32
+)
+
+// ErrSeedMasterMissing is returned when v2 entries exist but the seed master secret is not stored
+// A new secret would silently change their seeds, the stored one must be restored instead
+var ErrSeedMasterMissing = errors.New("the seed master secret is missing but entries were registered with it, restore it with keys import or keys recover")
+
+// ErrLegacySecretMissing is returned for the legacy seeds when the first issuer key is not a software key
+var ErrLegacySecretMissing = errors.New("the legacy seeds require the first issuer key to be a software key")
+
+// Stored seed master secret
+type seedMasterRecord struct {
+	SeedMaster []byte `json:"seed_master"` // base64
+}
+
+// Seed derivation version of the entry
+func (e DslEntry) seedVersion() int {
+	if e.SeedVersion == 0 {
+		return SeedVersionLegacy
+	}
+	return e.SeedVersion
+}
+
+// Pseudorandom key of the v2 entry seeds
+func seedKeyOf(master []byte) []byte {
+	return hkdf.Extract(sha256.New, master, []byte(seedMasterLabel))
+}
+
+// Seed of a v2 entry
+func hkdfSeed(seedKey []byte, jtiDigest [32]byte) ([32]byte, error) {
+	var seed [32]byte
+	info := append([]byte(seedEntryLabel), jtiDigest[:]...)
+	_, err := io.ReadFull(hkdf.Expand(sha256.New, seedKey, info), seed[:])
+	return seed, err
+}
+
+// Load or generate the seed master secret
+// It is only generated if no registered entry derives its seed from it
+func getSeedMaster(store Store, entries map[string]DslEntry) ([]byte, error) {
+	master, err := store.LoadSeedMaster()
+	if err == nil {
+		return master, nil
+	}
+	if !errors.Is(err, os.ErrNotExist) {
+		return nil, err
+	}
+
+	// Only one process generates the secret
+	unlock, err := LockFile(stateLockFile, true)
+	if err != nil {
+		return nil, err
+	}
+	defer unlock()
+	master, err = store.LoadSeedMaster()
+	if err == nil {
+		// Secret created by another process
+		return master, nil
+	}
+	for _, entry := range entries {
+		if entry.Arkg == nil && entry.seedVersion() == SeedVersionHKDF {
+			return nil, ErrSeedMasterMissing
+		}
+	}
+
+	// Existing entries keep their seed version, new entries use the new secret
+	fmt.Println("Generating a new seed master secret")
+	master = make([]byte, seedMasterSize)
+	if _, err := rand.Read(master); err != nil {
+		return nil, fmt.Errorf("failed to generate the seed master secret: %w", err)
+	}
+	if err := store.SaveSeedMaster(master); err != nil {
+		return nil, fmt.Errorf("failed to store: %w", err)
+	}
+	return master, nil
+}
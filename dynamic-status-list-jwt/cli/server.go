@@ -1,16 +1,15 @@
 package main
 
 import (
-	"crypto/ecdsa"
-	"crypto/elliptic"
-	"crypto/rand"
-	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
 	"errors"
 	"fmt"
-	"log"
+	"os"
+	"strings"
+	"sync"
+	"time"
 
-	"github.com/lestrrat-go/jwx/v3/jwa"
-	"github.com/lestrrat-go/jwx/v3/jwk"
 	"github.com/lestrrat-go/jwx/v3/jws"
 	"github.com/lestrrat-go/jwx/v3/jwt"
 )
@@ -21,159 +20,314 @@ const serverConfig = "config.json"
 
 // Server variables
 type Server struct {
-	SecretKey jwk.Key
-	PublicKey jwk.Key
-	key       ecdsa.PrivateKey
-	Secret    []byte           // sha256 hash of the secret key
-	Dsl       *map[string]bool // true: valid, false: invalid/revoked
-	DslJwt    []byte
+	Secret     []byte               // legacy (v1) seed secret, derived from the secret key
+	seedKey    []byte               // v2 seed key, derived from the seed master secret
+	Dsl        *map[string]DslEntry // jti -> status entry
+	DslJwt     []byte               // current window
+	DslWindows []DslJWT             // previous, current and next window
+	Config     ListConfig           // status list configuration
+	store      Store                // issuer state storage
+
+	keys    *KeyRing     // issuer signing keys
+	backend KeyBackend   // backend of the private issuer keys
+	mu      sync.RWMutex // guards Dsl, DslJwt, DslWindows and keys
+	seeds   sync.Map     // seedCacheKey -> *entrySecret, cached per-entry seeds
+	pastMu  sync.Mutex   // guards past
+	past    pastWindows  // signed past windows
 }
 
 // NewServer initializes and returns a new Server instance
 func NewServer() *Server {
-	// Load or create a server key
-	key, err := getServerKey()
-	if err != nil {
-		fmt.Println("Error retrieving server key:", err)
-		return nil
-	}
-
-	// Extract ECDSA private key from JWK key
-	var sk = &ecdsa.PrivateKey{}
-	err = jwk.Export(key, sk)
+	// Load the status list configuration
+	config, err := LoadListConfig(listConfigFile)
 	if err != nil {
-		fmt.Println("Error exporting private key:", err)
+		fmt.Println("Error loading status list configuration:", err)
 		return nil
 	}
 
-	// Convert the public key to JWK format
-	pk, err := jwk.Import(sk.PublicKey)
+	// Open the storage backend
+	store, err := OpenStore(config)
 	if err != nil {
-		fmt.Println("Error importing public key:", err)
+		fmt.Println("Error opening the store:", err)
 		return nil
 	}
 
 	// Initialize the Distributed Certificate Revocation List (DSL)
-	dsl, err := LoadDslMap("dsl-map.json")
+	dsl, err := store.LoadEntries()
 	if err != nil {
-		fmt.Println("> Init a new dsl map")
-		dsl = make(map[string]bool)
+		fmt.Println("Error loading the dsl map:", err)
+		return nil
 	}
 
-	// Derive a secret from the private key (hashing the private key's D value)
-	secret := sha256.New().Sum(sk.D.Bytes())
-
 	// Return a new Server instance with initialized fields
+	// The issuer keys and the seed master are loaded by the issuer commands only
 	return &Server{
-		SecretKey: key,      // Original server key
-		PublicKey: pk,       // Public key in JWK format
-		key:       *sk,      // ECDSA private key
-		Secret:    secret,   // Derived secret
-		Dsl:       &dsl,     // Distributed Certificate Revocation List
-		DslJwt:    []byte{}, // JWT representation of the DSL (empty for now)
+		Dsl:    &dsl,     // Distributed Certificate Revocation List
+		DslJwt: []byte{}, // JWT representation of the DSL (empty for now)
+		Config: config,   // Status list configuration
+		store:  store,    // Issuer state storage
 	}
 }
 
-// Load or Generate EC Private Key
-func getServerKey() (jwk.Key, error) {
-	key, err := LoadJWK(serverConfig)
-	if err == nil {
-		// Key loaded, exit
-		return key, nil
+// LoadIssuer opens the key backend and loads the issuer keys and the seed master,
+// they are generated on first use. The holder and verifier commands never call it,
+// so they do not unlock the keystore.
+func (s *Server) LoadIssuer(allowPlaintext bool) error {
+	if s.keyRing() != nil {
+		return nil
+	}
+	if err := s.importFileStore(); err != nil {
+		return fmt.Errorf("failed to import the file state: %w", err)
+	}
+
+	// Open the backend of the private keys
+	backend, err := OpenKeyBackend(s.Config)
+	if err != nil {
+		return fmt.Errorf("failed to open the key backend: %w", err)
 	}
 
-	// File doesn't exist, generate new ES256 key
-	fmt.Println("Generating new EC key (ES256)")
-	privKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	// Load or create the server keys
+	keys, err := getServerKeys(s.store, backend, s.Config.SigningAlgorithm(), allowPlaintext)
 	if err != nil {
-		return nil, fmt.Errorf("failed to generate EC key: %w", err)
+		return fmt.Errorf("failed to retrieve the issuer keys: %w", err)
 	}
 
-	// Convert to JWK format
-	jwkKey, err := jwk.Import(privKey)
+	// Seed master secret of the current seeds, independent of the signing key
+	// Without it the v2 entries have no seed until it is restored with keys import or keys recover
+	master, err := getSeedMaster(s.store, *s.dslSnapshot())
+	switch {
+	case errors.Is(err, ErrSeedMasterMissing):
+		fmt.Println("> Warning:", err)
+	case err != nil:
+		return fmt.Errorf("failed to retrieve the seed master secret: %w", err)
+	default:
+		s.seedKey = seedKeyOf(master)
+	}
+
+	s.backend = backend
+	s.Secret = legacySecret(keys)
+	s.setKeys(keys)
+	return nil
+}
+
+// OpenIssuerBackend opens the key backend without loading or generating the issuer keys,
+// the restore commands replace them. A store without keys needs a passphrase unless allowPlaintext.
+func (s *Server) OpenIssuerBackend(allowPlaintext bool) error {
+	if err := s.importFileStore(); err != nil {
+		return fmt.Errorf("failed to import the file state: %w", err)
+	}
+	backend, err := OpenKeyBackend(s.Config)
 	if err != nil {
-		return nil, fmt.Errorf("failed to create JWK from private key: %w", err)
+		return fmt.Errorf("failed to open the key backend: %w", err)
 	}
+	s.backend = backend
 
-	// Set key algorithm
-	jwkKey.Set(jwk.AlgorithmKey, jwa.ES256)
+	// Unlock the stored keys, the restored ones are sealed with the same passphrase
+	_, err = s.store.LoadIssuerKeys()
+	if errors.Is(err, os.ErrNotExist) {
+		return requireKeystorePassphrase(allowPlaintext)
+	}
+	return err
+}
 
-	// Save to config.json
-	err = SaveJSON(jwkKey, serverConfig)
+// Legacy secret of the v1 seeds, derived from the first private key
+// Kept only to recompute the entries registered before seed versioning, which had a software EC key
+func legacySecret(ring *KeyRing) []byte {
+	signer, err := ring.Legacy().Signer()
 	if err != nil {
-		return nil, fmt.Errorf("failed to store: %w", err)
+		return nil
 	}
+	secret, _ := signer.LegacySecret()
+	return secret
+}
+
+// DslJwtAt returns the signed dSL JWT whose window contains t
+func (s *Server) DslJwtAt(t int64) (DslJWT, bool) {
+	return s.DslJwtByNbf(DslWindowStart(t, s.Config.Period))
+}
 
-	return jwkKey, nil
+// DslJwtByNbf returns the signed dSL JWT of the window starting at nbf
+func (s *Server) DslJwtByNbf(nbf int64) (DslJWT, bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	for _, w := range s.DslWindows {
+		if w.Nbf == nbf {
+			return w, true
+		}
+	}
+	return DslJWT{}, false
 }
 
 // Sign a JWT with 'jwk' header claim
+// The JWT is signed with the active issuer key
 func (s *Server) SignJWT(t jwt.Token) ([]byte, error) {
+	key, err := s.signingKey()
+	if err != nil {
+		return nil, err
+	}
+	return signJWTWith(t, key)
+}
 
+// Sign a JWT with the given issuer key, with 'kid' and 'jwk' header claims
+func signJWTWith(t jwt.Token, key *IssuerKey) ([]byte, error) {
 	// Set the jwk header
 	h := jws.NewHeaders()
-	h.Set(jws.JWKKey, s.PublicKey)
+	h.Set(jws.KeyIDKey, key.Kid)
+	h.Set(jws.JWKKey, key.pub)
 
-	// Sign JWT
-	return jwt.Sign(t, jwt.WithKey(jwa.ES256(), s.key, jws.WithProtectedHeaders(h)))
+	// Sign JWT with the key's algorithm, through the key backend
+	signer, err := key.Signer()
+	if err != nil {
+		return nil, err
+	}
+	return jwt.Sign(t, jwt.WithKey(key.alg, signer, jws.WithProtectedHeaders(h)))
 }
 
-func Verify(dslJWTPath string, proofPath string) (bool, error) {
+// Issuer key that signs now
+func (s *Server) signingKey() (*IssuerKey, error) {
+	return s.keyRing().Active(time.Now().Unix())
+}
 
-	var err error
-	// Load the DSL
-	var dsl DslJWT
-	err = LoadJSON(&dsl, dslJWTPath)
+// Issuer identifier (iss) of the lists and credentials
+func (s *Server) issuer() (string, error) {
+	return s.keyRing().Issuer(s.Config.Issuer)
+}
+
+// Current issuer keys
+func (s *Server) keyRing() *KeyRing {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.keys
+}
+
+// Replace the issuer keys
+func (s *Server) setKeys(ring *KeyRing) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.keys = ring
+}
+
+// Reload the issuer keys, another process may have rotated them
+func (s *Server) reloadKeys() error {
+	ring, err := s.store.LoadIssuerKeys()
 	if err != nil {
-		return false, err
+		return err
 	}
-	t, err := jwt.Parse([]byte(dsl.DslJwt), jwt.WithVerify(false), jwt.WithValidate(false))
+	if err := s.backend.Attach(ring); err != nil {
+		return err
+	}
+	s.setKeys(ring)
+	return nil
+}
+
+// Verify checks the holder's proof against a trusted and valid status list
+func Verify(dslJWTPath string, proofPath string, opts VerifyOptions) (*VerificationResult, error) {
+	results, err := VerifyAll(dslJWTPath, []string{proofPath}, opts)
 	if err != nil {
-		return false, err
+		return nil, err
 	}
+	return results[0].Result, results[0].Err
+}
+
+// ProofResult is the outcome of the check of a single proof
+type ProofResult struct {
+	Path   string
+	Result *VerificationResult
+	Err    error
+}
 
-	var rawSid []interface{}
-	err = t.Get("sid", &rawSid)
+// VerifyAll loads the status list once and checks every holder's proof against it
+func VerifyAll(dslJWTPath string, proofPaths []string, opts VerifyOptions) ([]ProofResult, error) {
+	// Load and index the DSL windows
+	v := NewVerifier(opts)
+	_, err := v.LoadFile(dslJWTPath)
 	if err != nil {
-		return false, err
+		return nil, err
 	}
 
-	// Convert []interface{} to []string
-	var sid []string
-	for _, v := range rawSid {
-		if str, ok := v.(string); ok {
-			sid = append(sid, str)
-		} else {
-			log.Println("Warning: sid contains a non-string value")
+	return checkProofs(proofPaths, opts, v.Check), nil
+}
+
+// VerifyRemote fetches the status list from its distribution point and checks every holder's proof against it
+// The lists are cached in cacheDir for offline use
+func VerifyRemote(listURL string, proofPaths []string, opts VerifyOptions, cacheDir string) []ProofResult {
+	c := NewStatusClient(opts, cacheDir)
+	return checkProofs(proofPaths, opts, func(h HolderProofPayload) (*VerificationResult, error) {
+		return c.Check(listURL, h)
+	})
+}
+
+// Load and check the holder's proofs
+func checkProofs(proofPaths []string, opts VerifyOptions, check func(HolderProofPayload) (*VerificationResult, error)) []ProofResult {
+	results := make([]ProofResult, 0, len(proofPaths))
+	for _, path := range proofPaths {
+		// Load the holder's proof, and check its signature, audience, nonce and freshness
+		h, err := LoadHolderProof(path, opts)
+		if err != nil {
+			results = append(results, ProofResult{Path: path, Err: err})
+			continue
 		}
+
+		// Check the proof against the window it was computed for
+		result, err := check(h)
+		results = append(results, ProofResult{Path: path, Result: result, Err: err})
+	}
+	return results
+}
+
+// VerificationResult is the outcome of a status check
+type VerificationResult struct {
+	Status   EntryStatus // valid, suspended or revoked
+	Metadata *StatusInfo // decrypted status metadata, if the list publishes it
+	Stale    bool        // the issuer could not be reached, a cached list was used
+}
+
+// Decrypt the status metadata published for the matched sid
+func newVerificationResult(t jwt.Token, h HolderProofPayload, sid string, status EntryStatus) (*VerificationResult, error) {
+	result := &VerificationResult{Status: status}
+	if !t.Has("sme") {
+		return result, nil
 	}
 
-	// Load the DSL
-	var h HolderProofPayload
-	err = LoadJSON(&h, proofPath)
+	var sme map[string]interface{}
+	err := t.Get("sme", &sme)
 	if err != nil {
-		return false, err
+		return nil, err
+	}
+	blob, ok := sme[sid].(string)
+	if !ok {
+		return result, nil
 	}
 
-	sidValid, err := ComputeRevocationIdentifierWithToken(h.Jti, h.Token, true)
+	token, err := base64.RawURLEncoding.DecodeString(h.Token)
 	if err != nil {
-		return false, err
+		return nil, err
 	}
-	for _, v := range sid {
-		if v == sidValid {
-			return false, nil
-		}
+	result.Metadata, err = DecryptStatusInfo(token, h.Jti, sid, blob)
+	if err != nil {
+		return nil, err
 	}
-	sidInvalid, err := ComputeRevocationIdentifierWithToken(h.Jti, h.Token, false)
+	return result, nil
+}
+
+// LoadDslJWTs loads a single dSL (dsl.json) or a list of dSL windows (dsl-windows.json)
+func LoadDslJWTs(path string) ([]DslJWT, error) {
+	data, err := os.ReadFile(path)
 	if err != nil {
-		return false, err
+		return nil, fmt.Errorf("failed to read file: %w", err)
 	}
-	for _, v := range sid {
-		if v == sidInvalid {
-			return true, nil
+
+	if strings.HasPrefix(strings.TrimSpace(string(data)), "[") {
+		var lists []DslJWT
+		if err := json.Unmarshal(data, &lists); err != nil {
+			return nil, fmt.Errorf("failed to parse JSON: %w", err)
 		}
+		return lists, nil
 	}
 
-	return false, errors.New("status list id not found")
-
+	var dsl DslJWT
+	if err := json.Unmarshal(data, &dsl); err != nil {
+		return nil, fmt.Errorf("failed to parse JSON: %w", err)
+	}
+	return []DslJWT{dsl}, nil
 }
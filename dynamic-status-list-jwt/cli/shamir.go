@@ -0,0 +1,232 @@
+package main
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"time"
+)
+
+const (
+	shamirFormat   = "dsl/v1 shamir" // format of the shares, prefix of the secret digest
+	shamirSetSize  = 16              // size of the random share set identifier
+	shamirMaxCount = 255             // the shares are points of GF(2^8) polynomials
+	checksumSize   = 8               // size of the share checksum
+)
+
+// KeyShare is one of the N shares of the issuer keys and the seed master, M of them recover the secret
+//
+//	data     = f_1(x) || ... || f_L(x), one random polynomial of degree M-1 over GF(2^8) per secret byte
+//	digest   = SHA256("dsl/v1 shamir" || set || secret), checks the recovered secret
+//	checksum = SHA256(set || threshold || shares || index || data)[:8], detects a damaged share
+type KeyShare struct {
+	Format    string `json:"share"`
+	Set       string `json:"set"` // shares of the same split (hex)
+	Created   int64  `json:"created"`
+	Threshold int    `json:"threshold"` // M
+	Shares    int    `json:"shares"`    // N
+	Index     int    `json:"index"`     // x, from 1 to N
+	Data      []byte `json:"data"`      // base64
+	Digest    string `json:"digest"`    // hex
+	Checksum  string `json:"checksum"`  // hex
+}
+
+// Multiplication in GF(2^8) with the AES polynomial x^8 + x^4 + x^3 + x + 1, without secret-dependent branches
+func gfMul(a, b byte) byte {
+	var p byte
+	for range 8 {
+		p ^= -(b & 1) & a
+		a = a<<1 ^ 0x1b&-(a>>7)
+		b >>= 1
+	}
+	return p
+}
+
+// Inverse in GF(2^8): a^254
+func gfInv(a byte) byte {
+	x := gfMul(a, a)
+	r := x
+	for range 6 {
+		x = gfMul(x, x)
+		r = gfMul(r, x)
+	}
+	return r
+}
+
+// Split a secret into n shares, any m of them recover it
+func shamirSplit(secret []byte, m int, n int) ([][]byte, error) {
+	if m < 2 || m > n || n > shamirMaxCount {
+		return nil, fmt.Errorf("invalid %d-of-%d split: need 2 <= M <= N <= %d", m, n, shamirMaxCount)
+	}
+	shares := make([][]byte, n)
+	for i := range shares {
+		shares[i] = make([]byte, len(secret))
+	}
+	coefficients := make([]byte, m)
+	for b, s := range secret {
+		// f(x) = s + c_1 x + ... + c_{m-1} x^(m-1)
+		coefficients[0] = s
+		if _, err := rand.Read(coefficients[1:]); err != nil {
+			return nil, err
+		}
+		for i := range shares {
+			x := byte(i + 1)
+			var y byte
+			for j := m - 1; j >= 0; j-- {
+				y = gfMul(y, x) ^ coefficients[j]
+			}
+			shares[i][b] = y
+		}
+	}
+	clear(coefficients)
+	return shares, nil
+}
+
+// Recover the secret from shares at distinct non-zero points by Lagrange interpolation at 0
+func shamirCombine(xs []byte, shares [][]byte) []byte {
+	secret := make([]byte, len(shares[0]))
+	for i, xi := range xs {
+		// l_i(0) = prod_{j != i} x_j / (x_i + x_j)
+		l := byte(1)
+		for j, xj := range xs {
+			if i != j {
+				l = gfMul(l, gfMul(xj, gfInv(xi^xj)))
+			}
+		}
+		for b := range secret {
+			secret[b] ^= gfMul(l, shares[i][b])
+		}
+	}
+	return secret
+}
+
+// Digest of the secret of a share set
+func shamirDigest(set []byte, secret []byte) string {
+	h := sha256.New()
+	h.Write([]byte(shamirFormat))
+	h.Write(set)
+	h.Write(secret)
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// Checksum of the share fields
+func (k *KeyShare) checksum() string {
+	h := sha256.New()
+	h.Write([]byte(k.Set))
+	for _, v := range []int{k.Threshold, k.Shares, k.Index} {
+		h.Write(binary.BigEndian.AppendUint32(nil, uint32(v)))
+	}
+	h.Write(k.Data)
+	return hex.EncodeToString(h.Sum(nil)[:checksumSize])
+}
+
+// Check that a share is intact
+func (k *KeyShare) validate() error {
+	if k.Format != shamirFormat {
+		return errors.New("not a key share")
+	}
+	if subtle.ConstantTimeCompare([]byte(k.checksum()), []byte(k.Checksum)) != 1 {
+		return fmt.Errorf("share %d is damaged: checksum mismatch", k.Index)
+	}
+	if k.Threshold < 2 || k.Threshold > k.Shares || k.Shares > shamirMaxCount || k.Index < 1 || k.Index > k.Shares {
+		return fmt.Errorf("share %d has an invalid %d-of-%d split", k.Index, k.Threshold, k.Shares)
+	}
+	return nil
+}
+
+// SplitKeys splits the issuer keys and the seed master into n shares, any m of them recover them
+func (s *Server) SplitKeys(m int, n int) ([]KeyShare, error) {
+	secret, err := s.keystoreSnapshot()
+	if err != nil {
+		return nil, err
+	}
+	defer clear(secret)
+	return splitSecret(secret, m, n)
+}
+
+// Split a secret into n key shares of a new set
+func splitSecret(secret []byte, m int, n int) ([]KeyShare, error) {
+	data, err := shamirSplit(secret, m, n)
+	if err != nil {
+		return nil, err
+	}
+
+	set := make([]byte, shamirSetSize)
+	if _, err := rand.Read(set); err != nil {
+		return nil, err
+	}
+	shares := make([]KeyShare, n)
+	for i := range shares {
+		k := &shares[i]
+		*k = KeyShare{
+			Format:    shamirFormat,
+			Set:       hex.EncodeToString(set),
+			Created:   time.Now().Unix(),
+			Threshold: m,
+			Shares:    n,
+			Index:     i + 1,
+			Data:      data[i],
+			Digest:    shamirDigest(set, secret),
+		}
+		k.Checksum = k.checksum()
+	}
+	return shares, nil
+}
+
+// RecoverKeys recovers the issuer keys and the seed master from M shares and restores them
+func (s *Server) RecoverKeys(shares []KeyShare, force bool) (*KeyRing, error) {
+	secret, err := recoverSecret(shares)
+	if err != nil {
+		return nil, err
+	}
+	defer clear(secret)
+	return s.restoreKeystore(secret, force)
+}
+
+// Recover the secret of M key shares, checked against the digest of the set
+func recoverSecret(shares []KeyShare) ([]byte, error) {
+	if len(shares) == 0 {
+		return nil, errors.New("no shares")
+	}
+	first := shares[0]
+	xs := make([]byte, 0, len(shares))
+	data := make([][]byte, 0, len(shares))
+	for _, k := range shares {
+		if err := k.validate(); err != nil {
+			return nil, err
+		}
+		if k.Set != first.Set || k.Threshold != first.Threshold || k.Shares != first.Shares || k.Digest != first.Digest {
+			return nil, fmt.Errorf("share %d belongs to another split", k.Index)
+		}
+		if len(k.Data) != len(first.Data) {
+			return nil, fmt.Errorf("share %d has a different length", k.Index)
+		}
+		for _, x := range xs {
+			if int(x) == k.Index {
+				return nil, fmt.Errorf("share %d is given twice", k.Index)
+			}
+		}
+		xs = append(xs, byte(k.Index))
+		data = append(data, k.Data)
+	}
+	if len(shares) < first.Threshold {
+		return nil, fmt.Errorf("%d of the %d shares are needed, got %d", first.Threshold, first.Shares, len(shares))
+	}
+
+	// The digest shows all the shares were taken from the same secret
+	secret := shamirCombine(xs, data)
+	set, err := hex.DecodeString(first.Set)
+	if err != nil {
+		clear(secret)
+		return nil, err
+	}
+	if subtle.ConstantTimeCompare([]byte(shamirDigest(set, secret)), []byte(first.Digest)) != 1 {
+		clear(secret)
+		return nil, errors.New("the shares do not recover the secret: a share was altered")
+	}
+	return secret, nil
+}
@@ -0,0 +1,135 @@
+package main
+
+import (
+	"bytes"
+	"crypto/rand"
+	"fmt"
+	"testing"
+)
+
+func newTestSecret(t *testing.T, size int) []byte {
+	t.Helper()
+	secret := make([]byte, size)
+	if _, err := rand.Read(secret); err != nil {
+		t.Fatal(err)
+	}
+	return secret
+}
+
+// Every subset of k of the shares
+func shareSubsets(shares []KeyShare, k int) [][]KeyShare {
+	if k == 0 {
+		return [][]KeyShare{nil}
+	}
+	var subsets [][]KeyShare
+	for i := 0; i+k <= len(shares); i++ {
+		for _, rest := range shareSubsets(shares[i+1:], k-1) {
+			subsets = append(subsets, append([]KeyShare{shares[i]}, rest...))
+		}
+	}
+	return subsets
+}
+
+func TestShamirRoundTrip(t *testing.T) {
+	for _, tc := range []struct{ m, n int }{{2, 2}, {2, 3}, {3, 5}, {5, 5}, {4, 7}} {
+		t.Run(fmt.Sprintf("%d-of-%d", tc.m, tc.n), func(t *testing.T) {
+			secret := newTestSecret(t, 100)
+			shares, err := splitSecret(secret, tc.m, tc.n)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			// Any M shares recover the secret, in any order
+			for _, subset := range shareSubsets(shares, tc.m) {
+				recovered, err := recoverSecret(subset)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if !bytes.Equal(recovered, secret) {
+					t.Fatal("the shares recovered another secret")
+				}
+				subset[0], subset[len(subset)-1] = subset[len(subset)-1], subset[0]
+				if recovered, err = recoverSecret(subset); err != nil || !bytes.Equal(recovered, secret) {
+					t.Fatalf("reordered shares: %v", err)
+				}
+			}
+
+			// Fewer than M shares are rejected, and do not interpolate to the secret
+			for _, subset := range shareSubsets(shares, tc.m-1) {
+				if _, err := recoverSecret(subset); err == nil {
+					t.Fatalf("%d shares recovered a %d-of-%d split", len(subset), tc.m, tc.n)
+				}
+				xs := make([]byte, len(subset))
+				data := make([][]byte, len(subset))
+				for i, k := range subset {
+					xs[i], data[i] = byte(k.Index), k.Data
+				}
+				if bytes.Equal(shamirCombine(xs, data), secret) {
+					t.Fatal("fewer than M shares interpolate to the secret")
+				}
+			}
+		})
+	}
+}
+
+func TestShamirInvalidSplit(t *testing.T) {
+	secret := newTestSecret(t, 32)
+	for _, tc := range []struct{ m, n int }{{1, 3}, {4, 3}, {2, shamirMaxCount + 1}} {
+		if _, err := splitSecret(secret, tc.m, tc.n); err == nil {
+			t.Errorf("%d-of-%d split accepted", tc.m, tc.n)
+		}
+	}
+}
+
+func TestShamirTamperedShare(t *testing.T) {
+	secret := newTestSecret(t, 64)
+	shares, err := splitSecret(secret, 3, 5)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// A damaged share fails its checksum
+	damaged := append([]KeyShare{}, shares[:3]...)
+	damaged[1].Data = bytes.Clone(damaged[1].Data)
+	damaged[1].Data[0] ^= 1
+	if _, err := recoverSecret(damaged); err == nil {
+		t.Fatal("a share with a checksum mismatch was accepted")
+	}
+
+	// An altered share with a matching checksum fails the digest of the secret
+	altered := append([]KeyShare{}, shares[:3]...)
+	altered[1].Data = bytes.Clone(altered[1].Data)
+	altered[1].Data[0] ^= 1
+	altered[1].Checksum = altered[1].checksum()
+	if _, err := recoverSecret(altered); err == nil {
+		t.Fatal("an altered share recovered a secret")
+	}
+
+	// A share of another split of the same secret
+	other, err := splitSecret(secret, 3, 5)
+	if err != nil {
+		t.Fatal(err)
+	}
+	mixed := []KeyShare{shares[0], shares[1], other[2]}
+	if _, err := recoverSecret(mixed); err == nil {
+		t.Fatal("shares of different splits were combined")
+	}
+}
+
+func TestShamirDuplicateShares(t *testing.T) {
+	shares, err := splitSecret(newTestSecret(t, 32), 3, 5)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := recoverSecret([]KeyShare{shares[0], shares[1], shares[1]}); err == nil {
+		t.Fatal("a duplicated share was accepted")
+	}
+}
+
+func TestGFInverse(t *testing.T) {
+	for a := 1; a < 256; a++ {
+		if p := gfMul(byte(a), gfInv(byte(a))); p != 1 {
+			t.Fatalf("%#02x * inv(%#02x) = %#02x", a, a, p)
+		}
+	}
+}
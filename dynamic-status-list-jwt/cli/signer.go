@@ -0,0 +1,131 @@
+package main
+
+import (
+	"crypto"
+	"crypto/ecdh"
+	"crypto/ecdsa"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+
+	"github.com/lestrrat-go/jwx/v3/jwa"
+	"github.com/lestrrat-go/jwx/v3/jwk"
+)
+
+// Backends of the private issuer keys
+const (
+	KeyBackendSoftware = "software" // private JWKs in the store (default)
+	KeyBackendPKCS11   = "pkcs11"   // non-extractable keys in a PKCS#11 token
+)
+
+// Environment variable with the user PIN of the PKCS#11 token, the PIN is never stored
+const pkcs11PinEnv = "DSL_PKCS11_PIN"
+
+// PKCS11Config selects the token of the pkcs11 key backend
+type PKCS11Config struct {
+	Module string `json:"module,omitempty"` // path of the PKCS#11 library, e.g. /usr/lib/softhsm/libsofthsm2.so
+	Token  string `json:"token,omitempty"`  // token label
+}
+
+// Validate checks the token settings
+func (c *PKCS11Config) Validate() error {
+	if c == nil || c.Module == "" || c.Token == "" {
+		return errors.New("the pkcs11 key backend requires a module path and a token label")
+	}
+	return nil
+}
+
+// IssuerSigner performs the private key operations of an issuer key
+// The private key itself may not be accessible, e.g. when it lives in a PKCS#11 token
+type IssuerSigner interface {
+	// Sign a digest (ECDSA, RSA) or a message (EdDSA), the ECDSA signatures are ASN.1 encoded
+	crypto.Signer
+	// ECDH shared secret with a public key of the same curve, the issuer side of the ARKG seeds
+	ECDH(pub *ecdh.PublicKey) ([]byte, error)
+	// LegacySecret is the secret of the v1 seeds, it needs the raw private key
+	LegacySecret() ([]byte, error)
+}
+
+// KeyBackend generates the issuer keys and provides the signers of the stored keys
+type KeyBackend interface {
+	// Generate a new issuer key for the signature algorithm
+	Generate(alg jwa.SignatureAlgorithm, activates int64) (*IssuerKey, error)
+	// Attach the signers of the keys held by the backend to a loaded key ring
+	Attach(ring *KeyRing) error
+}
+
+// OpenKeyBackend opens the key backend selected in the status list configuration
+func OpenKeyBackend(config ListConfig) (KeyBackend, error) {
+	switch config.KeyBackend {
+	case "", KeyBackendSoftware:
+		return softwareBackend{}, nil
+	case KeyBackendPKCS11:
+		return openPKCS11Backend(config.PKCS11)
+	default:
+		return nil, fmt.Errorf("unknown key backend %q", config.KeyBackend)
+	}
+}
+
+// Software backend: the private keys are JWKs stored with the key ring
+type softwareBackend struct{}
+
+func (softwareBackend) Generate(alg jwa.SignatureAlgorithm, activates int64) (*IssuerKey, error) {
+	privKey, err := generateKey(alg.String())
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate the key: %w", err)
+	}
+
+	// Convert to JWK format
+	jwkKey, err := jwk.Import(privKey)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create JWK from private key: %w", err)
+	}
+	if err := jwkKey.Set(jwk.AlgorithmKey, alg); err != nil {
+		return nil, err
+	}
+	return NewIssuerKey(jwkKey, "", activates)
+}
+
+// The signers of the software keys are set when the ring is parsed
+// The token keys stay closed, using them fails
+func (softwareBackend) Attach(ring *KeyRing) error {
+	return nil
+}
+
+// Signer of a private JWK
+type softwareSigner struct {
+	crypto.Signer
+}
+
+func newSoftwareSigner(key jwk.Key) (*softwareSigner, error) {
+	var raw interface{}
+	if err := jwk.Export(key, &raw); err != nil {
+		return nil, err
+	}
+	signer, ok := raw.(crypto.Signer)
+	if !ok {
+		return nil, fmt.Errorf("a %s key can not sign", key.KeyType())
+	}
+	return &softwareSigner{signer}, nil
+}
+
+func (s *softwareSigner) ECDH(pub *ecdh.PublicKey) ([]byte, error) {
+	sk, ok := s.Signer.(*ecdsa.PrivateKey)
+	if !ok {
+		return nil, errors.New("ECDH requires an EC issuer key")
+	}
+	ecdhKey, err := sk.ECDH()
+	if err != nil {
+		return nil, err
+	}
+	return ecdhKey.ECDH(pub)
+}
+
+// The private key's D value with an empty hash appended
+func (s *softwareSigner) LegacySecret() ([]byte, error) {
+	sk, ok := s.Signer.(*ecdsa.PrivateKey)
+	if !ok {
+		return nil, errors.New("the legacy seeds require an EC issuer key")
+	}
+	return sha256.New().Sum(sk.D.Bytes()), nil
+}
@@ -0,0 +1,187 @@
+package main
+
+import (
+	"crypto/sha256"
+	"fmt"
+	"sync"
+
+	"github.com/lestrrat-go/jwx/v3/jwt"
+)
+
+// StatusList is a verified dSL window with its identifiers indexed for lookups
+// Load it once and check any number of proofs against it
+type StatusList struct {
+	Token  jwt.Token
+	Nbf    int64 // start of the window
+	Nxt    int64 // start of the next window
+	Period int64 // dSL period in seconds
+	Set    StatusSet
+
+	digest [32]byte // SHA256 of the signed list, to detect a changed list for the same window
+}
+
+// LoadStatusList verifies the signature of a dSL JWT and indexes its identifiers
+func LoadStatusList(raw []byte, opts VerifyOptions) (*StatusList, error) {
+	t, err := ParseDslJWT(raw, opts)
+	if err != nil {
+		return nil, err
+	}
+	nbf, nxt, err := dslWindow(t)
+	if err != nil {
+		return nil, err
+	}
+	period, err := DslPeriod(t)
+	if err != nil {
+		return nil, err
+	}
+	set, err := StatusSetOf(t)
+	if err != nil {
+		return nil, err
+	}
+	return &StatusList{Token: t, Nbf: nbf, Nxt: nxt, Period: period, Set: set, digest: sha256.Sum256(raw)}, nil
+}
+
+// Covers reports whether the window contains t
+func (l *StatusList) Covers(t int64) bool {
+	return l.Nbf <= t && t < l.Nxt
+}
+
+// Check looks up the holder's proof in the list
+// The list must be valid at verification time and its window must contain the proof's iat
+func (l *StatusList) Check(h HolderProofPayload, opts VerifyOptions) (*VerificationResult, error) {
+	if !l.Covers(h.Iat) {
+		return nil, fmt.Errorf("status list window [%d, %d) does not cover time %d", l.Nbf, l.Nxt, h.Iat)
+	}
+
+	// Reject lists that are not valid at verification time
+	err := ValidateDslJWT(l.Token, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	// The list and the credential of a signed proof must come from the same issuer
+	if h.issuer != "" {
+		var iss string
+		if err := l.Token.Get(jwt.IssuerKey, &iss); err != nil || iss != h.issuer {
+			return nil, ErrProofIssuer
+		}
+	}
+
+	// Look up the identifier of every status: valid, suspended and revoked
+	status, sid, err := LookupStatus(l.Set, h.Jti, h.Token)
+	if err != nil {
+		return nil, err
+	}
+	return newVerificationResult(l.Token, h, sid, status)
+}
+
+// Verifier checks holder proofs against cached status lists, keyed by nbf
+// It is safe for concurrent use
+type Verifier struct {
+	opts VerifyOptions
+
+	mu      sync.RWMutex
+	lists   map[int64]*StatusList // nbf -> list
+	periods map[int64]int         // period -> number of cached lists with that period
+}
+
+// NewVerifier returns a verifier that trusts lists according to opts
+func NewVerifier(opts VerifyOptions) *Verifier {
+	return &Verifier{opts: opts, lists: map[int64]*StatusList{}, periods: map[int64]int{}}
+}
+
+// Load verifies and caches a signed dSL JWT
+// A list that is already cached for its nbf is not verified and indexed again
+func (v *Verifier) Load(raw []byte) (*StatusList, error) {
+	// The unverified nbf only selects the cached list, the digest must match
+	digest := sha256.Sum256(raw)
+	if t, err := jwt.Parse(raw, jwt.WithVerify(false), jwt.WithValidate(false)); err == nil {
+		if nbf, _, err := dslWindow(t); err == nil {
+			v.mu.RLock()
+			l, ok := v.lists[nbf]
+			v.mu.RUnlock()
+			if ok && l.digest == digest {
+				return l, nil
+			}
+		}
+	}
+
+	l, err := LoadStatusList(raw, v.opts)
+	if err != nil {
+		return nil, err
+	}
+
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	v.store(l)
+	return l, nil
+}
+
+// Cache a list, replacing the list of the same window
+// The caller holds the write lock
+func (v *Verifier) store(l *StatusList) {
+	if old, ok := v.lists[l.Nbf]; ok {
+		v.forget(old)
+	}
+	v.lists[l.Nbf] = l
+	v.periods[l.Period]++
+}
+
+// Drop a cached list
+// The caller holds the write lock
+func (v *Verifier) forget(l *StatusList) {
+	delete(v.lists, l.Nbf)
+	if v.periods[l.Period]--; v.periods[l.Period] == 0 {
+		delete(v.periods, l.Period)
+	}
+}
+
+// LoadFile verifies and caches the lists of a dsl.json or dsl-windows.json file
+func (v *Verifier) LoadFile(path string) ([]*StatusList, error) {
+	windows, err := LoadDslJWTs(path)
+	if err != nil {
+		return nil, err
+	}
+	lists := make([]*StatusList, 0, len(windows))
+	for _, w := range windows {
+		l, err := v.Load([]byte(w.DslJwt))
+		if err != nil {
+			return nil, err
+		}
+		lists = append(lists, l)
+	}
+	return lists, nil
+}
+
+// List returns the cached list whose window contains t
+// The window start is computed for every period of the cached lists, usually a single one
+func (v *Verifier) List(t int64) (*StatusList, bool) {
+	v.mu.RLock()
+	defer v.mu.RUnlock()
+	for period := range v.periods {
+		if l, ok := v.lists[DslWindowStart(t, period)]; ok && l.Covers(t) {
+			return l, true
+		}
+	}
+	return nil, false
+}
+
+// Prune drops the lists whose window ended before t
+func (v *Verifier) Prune(t int64) {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	for _, l := range v.lists {
+		if l.Nxt <= t {
+			v.forget(l)
+		}
+	}
+}
+
+// Check verifies a holder's proof against the cached list of the proof's window
+func (v *Verifier) Check(h HolderProofPayload) (*VerificationResult, error) {
+	l, ok := v.List(h.Iat)
+	if !ok {
+		return nil, fmt.Errorf("no status list window covers time %d", h.Iat)
+	}
+	return l.Check(h, v.opts)
+}
@@ -0,0 +1,120 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+)
+
+// Storage backends
+const (
+	StoreFile = "file" // JSON files in the working directory (default)
+	StoreBolt = "bolt" // embedded transactional key-value database
+)
+
+// Store persists the issuer state: the dSL map, the status event log, the issuer keys and the seed master secret
+// The published lists (dsl.json, dsl-windows.json) are always written as files for verifiers
+type Store interface {
+	// LoadEntries returns all the dSL map entries
+	LoadEntries() (map[string]DslEntry, error)
+	// PutEntry creates or updates a single entry
+	PutEntry(jti string, entry DslEntry) error
+
+	// AppendEvent appends a status event to the log
+	AppendEvent(ev StatusEvent) error
+	// Events returns all the status events in the order they were recorded
+	Events() ([]StatusEvent, error)
+	// RecordEvent appends a status event and writes the entry it produced, both or neither
+	RecordEvent(ev StatusEvent, entry DslEntry) error
+
+	// LoadIssuerKeys returns the issuer keys or an error wrapping os.ErrNotExist
+	LoadIssuerKeys() (*KeyRing, error)
+	// SaveIssuerKeys stores the issuer keys
+	SaveIssuerKeys(ring *KeyRing) error
+
+	// LoadSeedMaster returns the seed master secret or an error wrapping os.ErrNotExist
+	LoadSeedMaster() ([]byte, error)
+	// SaveSeedMaster stores the seed master secret
+	SaveSeedMaster(master []byte) error
+}
+
+// OpenStore opens the storage backend selected in the status list configuration
+func OpenStore(config ListConfig) (Store, error) {
+	switch config.Store {
+	case "", StoreFile:
+		return NewFileStore(), nil
+	case StoreBolt:
+		// The file state is imported by the issuer commands, see importFileStore
+		return NewBoltStore(config.StorePath)
+	default:
+		return nil, fmt.Errorf("unknown store %q", config.Store)
+	}
+}
+
+// Import the file state the first time the bolt store is used
+// Only the issuer commands run it: reading the file keystore may ask for its passphrase
+func (s *Server) importFileStore() error {
+	if s.Config.Store != StoreBolt {
+		return nil
+	}
+	imported, err := importFileState(s.store)
+	if err != nil || !imported {
+		return err
+	}
+
+	// The entries were loaded from the empty store
+	dsl, err := s.store.LoadEntries()
+	if err != nil {
+		return err
+	}
+	s.mu.Lock()
+	s.Dsl = &dsl
+	s.mu.Unlock()
+	return nil
+}
+
+// Copy the file state into an empty store, reports whether there was a state to import
+func importFileState(to Store) (bool, error) {
+	if _, err := to.LoadIssuerKeys(); !errors.Is(err, os.ErrNotExist) {
+		// The store is already in use
+		return false, err
+	}
+	from := NewFileStore()
+	keys, err := from.LoadIssuerKeys()
+	if errors.Is(err, os.ErrNotExist) {
+		// Nothing to import
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+
+	fmt.Println("> Importing the issuer state from the JSON files")
+	entries, err := from.LoadEntries()
+	if err != nil {
+		return false, err
+	}
+	for jti, entry := range entries {
+		if err := to.PutEntry(jti, entry); err != nil {
+			return false, err
+		}
+	}
+	events, err := from.Events()
+	if err != nil {
+		return false, err
+	}
+	for _, ev := range events {
+		if err := to.AppendEvent(ev); err != nil {
+			return false, err
+		}
+	}
+	master, err := from.LoadSeedMaster()
+	if err == nil {
+		err = to.SaveSeedMaster(master)
+	}
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return false, err
+	}
+	// The keys are imported last, they mark the store as in use
+	return true, to.SaveIssuerKeys(keys)
+}
@@ -0,0 +1,238 @@
+package main
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"os"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+const defaultBoltPath = "dsl.db"
+
+// Buckets of the bolt store
+var (
+	entriesBucket  = []byte("entries") // jti -> DslEntry
+	eventsBucket   = []byte("events")  // sequence number -> StatusEvent
+	issuerBucket   = []byte("issuer")  // issuer state
+	issuerKeyName  = []byte("key")     // single JWK, before key rotation
+	issuerKeysName = []byte("keys")
+	seedMasterName = []byte("seed_master")
+)
+
+// BoltStore keeps the issuer state in an embedded bbolt database
+// Each update is a single transaction that only writes the changed entry
+type BoltStore struct {
+	path string
+}
+
+// NewBoltStore creates the database and its buckets
+func NewBoltStore(path string) (*BoltStore, error) {
+	if path == "" {
+		path = defaultBoltPath
+	}
+	b := &BoltStore{path: path}
+	err := b.update(func(tx *bolt.Tx) error {
+		for _, name := range [][]byte{entriesBucket, eventsBucket, issuerBucket} {
+			if _, err := tx.CreateBucketIfNotExists(name); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return b, nil
+}
+
+// The database is opened per operation, so several CLI processes can share it
+func (b *BoltStore) open(readOnly bool) (*bolt.DB, error) {
+	db, err := bolt.Open(b.path, 0600, &bolt.Options{Timeout: 10 * time.Second, ReadOnly: readOnly})
+	if err != nil {
+		return nil, fmt.Errorf("failed to open %s: %w", b.path, err)
+	}
+	return db, nil
+}
+
+func (b *BoltStore) update(fn func(tx *bolt.Tx) error) error {
+	db, err := b.open(false)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+	return db.Update(fn)
+}
+
+func (b *BoltStore) view(fn func(tx *bolt.Tx) error) error {
+	db, err := b.open(true)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+	return db.View(fn)
+}
+
+func (b *BoltStore) LoadEntries() (map[string]DslEntry, error) {
+	m := make(map[string]DslEntry)
+	err := b.view(func(tx *bolt.Tx) error {
+		return tx.Bucket(entriesBucket).ForEach(func(k, v []byte) error {
+			var entry DslEntry
+			if err := json.Unmarshal(v, &entry); err != nil {
+				return fmt.Errorf("invalid entry for jti %s: %w", k, err)
+			}
+			m[string(k)] = entry
+			return nil
+		})
+	})
+	return m, err
+}
+
+func (b *BoltStore) PutEntry(jti string, entry DslEntry) error {
+	data, err := json.Marshal(entry)
+	if err != nil {
+		return fmt.Errorf("failed to marshal JSON: %w", err)
+	}
+	return b.update(func(tx *bolt.Tx) error {
+		return tx.Bucket(entriesBucket).Put([]byte(jti), data)
+	})
+}
+
+func (b *BoltStore) AppendEvent(ev StatusEvent) error {
+	return b.update(func(tx *bolt.Tx) error {
+		return appendBoltEvent(tx, ev)
+	})
+}
+
+// RecordEvent appends the event and writes the entry in a single transaction
+func (b *BoltStore) RecordEvent(ev StatusEvent, entry DslEntry) error {
+	data, err := json.Marshal(entry)
+	if err != nil {
+		return fmt.Errorf("failed to marshal JSON: %w", err)
+	}
+	return b.update(func(tx *bolt.Tx) error {
+		if err := appendBoltEvent(tx, ev); err != nil {
+			return err
+		}
+		return tx.Bucket(entriesBucket).Put([]byte(ev.Jti), data)
+	})
+}
+
+// Append an event under the next sequence number
+func appendBoltEvent(tx *bolt.Tx, ev StatusEvent) error {
+	if ev.Recorded == 0 {
+		ev.Recorded = time.Now().Unix()
+	}
+	data, err := json.Marshal(ev)
+	if err != nil {
+		return fmt.Errorf("failed to marshal event: %w", err)
+	}
+	bucket := tx.Bucket(eventsBucket)
+	seq, err := bucket.NextSequence()
+	if err != nil {
+		return err
+	}
+	key := make([]byte, 8)
+	binary.BigEndian.PutUint64(key, seq)
+	return bucket.Put(key, data)
+}
+
+func (b *BoltStore) Events() ([]StatusEvent, error) {
+	var events []StatusEvent
+	err := b.view(func(tx *bolt.Tx) error {
+		// Keys are big endian sequence numbers, so the cursor returns them in order
+		return tx.Bucket(eventsBucket).ForEach(func(k, v []byte) error {
+			var ev StatusEvent
+			if err := json.Unmarshal(v, &ev); err != nil {
+				return fmt.Errorf("invalid event %d: %w", binary.BigEndian.Uint64(k), err)
+			}
+			events = append(events, ev)
+			return nil
+		})
+	})
+	return events, err
+}
+
+// The issuer key written before key rotation (a single JWK) is read as a key ring
+func (b *BoltStore) LoadIssuerKeys() (*KeyRing, error) {
+	var data []byte
+	err := b.view(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket(issuerBucket)
+		data = append(data, bucket.Get(issuerKeysName)...)
+		if len(data) == 0 {
+			data = append(data, bucket.Get(issuerKeyName)...)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	if len(data) == 0 {
+		return nil, fmt.Errorf("failed to load the issuer key: %w", os.ErrNotExist)
+	}
+	data, sealed, err := openSecret(data)
+	if err != nil {
+		return nil, err
+	}
+	ring, _, err := decodeKeyRing(data)
+	if err != nil {
+		return nil, err
+	}
+	if !sealed && keystoreSealed() {
+		fmt.Println("> Encrypting the issuer keys with the keystore passphrase")
+		if err := b.SaveIssuerKeys(ring); err != nil {
+			return nil, err
+		}
+	}
+	return ring, nil
+}
+
+func (b *BoltStore) SaveIssuerKeys(ring *KeyRing) error {
+	data, err := json.Marshal(ring)
+	if err != nil {
+		return fmt.Errorf("failed to marshal: %w", err)
+	}
+	if data, err = sealSecret(data); err != nil {
+		return err
+	}
+	return b.update(func(tx *bolt.Tx) error {
+		return tx.Bucket(issuerBucket).Put(issuerKeysName, data)
+	})
+}
+
+func (b *BoltStore) LoadSeedMaster() ([]byte, error) {
+	var master []byte
+	err := b.view(func(tx *bolt.Tx) error {
+		master = append(master, tx.Bucket(issuerBucket).Get(seedMasterName)...)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	if len(master) == 0 {
+		return nil, fmt.Errorf("failed to load the seed master secret: %w", os.ErrNotExist)
+	}
+	master, sealed, err := openSecret(master)
+	if err != nil {
+		return nil, err
+	}
+	if !sealed && keystoreSealed() {
+		fmt.Println("> Encrypting the seed master secret with the keystore passphrase")
+		if err := b.SaveSeedMaster(master); err != nil {
+			return nil, err
+		}
+	}
+	return master, nil
+}
+
+func (b *BoltStore) SaveSeedMaster(master []byte) error {
+	data, err := sealSecret(master)
+	if err != nil {
+		return err
+	}
+	return b.update(func(tx *bolt.Tx) error {
+		return tx.Bucket(issuerBucket).Put(seedMasterName, data)
+	})
+}
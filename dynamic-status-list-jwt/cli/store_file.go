@@ -0,0 +1,236 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"time"
+)
+
+const (
+	dslMapFile     = "dsl-map.json"
+	dslPendingFile = "dsl-pending.json"
+)
+
+// FileStore keeps the issuer state in JSON files in the working directory
+// Every entry update rewrites the whole dsl-map.json
+type FileStore struct {
+	MapPath     string // dSL map
+	EventsPath  string // status event log (JSON lines)
+	PendingPath string // event and entry being recorded
+	KeyPath     string // issuer keys
+	SeedPath    string // seed master secret
+}
+
+// NewFileStore returns a store using the default file names
+func NewFileStore() *FileStore {
+	return &FileStore{
+		MapPath:     dslMapFile,
+		EventsPath:  dslEventLog,
+		PendingPath: dslPendingFile,
+		KeyPath:     serverConfig,
+		SeedPath:    seedMasterFile,
+	}
+}
+
+// A status event and the entry it produced
+// The record is written before the log and the map, a write interrupted by a crash is completed from it
+type pendingRecord struct {
+	Event StatusEvent `json:"event"`
+	Entry DslEntry    `json:"entry"`
+}
+
+// The entries include the pending record
+func (f *FileStore) LoadEntries() (map[string]DslEntry, error) {
+	m, err := f.loadMap()
+	if err != nil {
+		return nil, err
+	}
+	pending, err := f.pending()
+	if err != nil {
+		return nil, err
+	}
+	if pending != nil {
+		m[pending.Event.Jti] = pending.Entry
+	}
+	return m, nil
+}
+
+func (f *FileStore) PutEntry(jti string, entry DslEntry) error {
+	m, err := f.LoadEntries()
+	if err != nil {
+		return err
+	}
+	m[jti] = entry
+	return SaveDslMap(f.MapPath, m)
+}
+
+func (f *FileStore) AppendEvent(ev StatusEvent) error {
+	return AppendEvent(f.EventsPath, ev)
+}
+
+// RecordEvent writes the pending record, then appends the event and updates the map
+func (f *FileStore) RecordEvent(ev StatusEvent, entry DslEntry) error {
+	// Complete a write interrupted by a crash first
+	if err := f.completePending(); err != nil {
+		return err
+	}
+	if ev.Recorded == 0 {
+		ev.Recorded = time.Now().Unix()
+	}
+	if err := SaveJSON(pendingRecord{Event: ev, Entry: entry}, f.PendingPath); err != nil {
+		return err
+	}
+	return f.completePending()
+}
+
+// The events include the pending record
+func (f *FileStore) Events() ([]StatusEvent, error) {
+	events, err := LoadEvents(f.EventsPath)
+	if err != nil {
+		return nil, err
+	}
+	pending, err := f.pending()
+	if err != nil {
+		return nil, err
+	}
+	if pending != nil {
+		logged, err := isLastEvent(events, pending.Event)
+		if err != nil {
+			return nil, err
+		}
+		if !logged {
+			events = append(events, pending.Event)
+		}
+	}
+	return events, nil
+}
+
+// The dSL map without the pending record
+func (f *FileStore) loadMap() (map[string]DslEntry, error) {
+	m, err := LoadDslMap(f.MapPath)
+	if errors.Is(err, os.ErrNotExist) {
+		return make(map[string]DslEntry), nil
+	}
+	return m, err
+}
+
+// The pending record, or nil
+func (f *FileStore) pending() (*pendingRecord, error) {
+	data, err := os.ReadFile(f.PendingPath)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read %s: %w", f.PendingPath, err)
+	}
+	var record pendingRecord
+	if err := json.Unmarshal(data, &record); err != nil {
+		return nil, fmt.Errorf("invalid pending record in %s: %w", f.PendingPath, err)
+	}
+	return &record, nil
+}
+
+// Append the pending event unless the log already ends with it, update the map and remove the record
+// The caller holds the state lock
+func (f *FileStore) completePending() error {
+	pending, err := f.pending()
+	if pending == nil || err != nil {
+		return err
+	}
+	events, err := LoadEvents(f.EventsPath)
+	if err != nil {
+		return err
+	}
+	logged, err := isLastEvent(events, pending.Event)
+	if err != nil {
+		return err
+	}
+	if !logged {
+		if err := AppendEvent(f.EventsPath, pending.Event); err != nil {
+			return err
+		}
+	}
+	m, err := f.loadMap()
+	if err != nil {
+		return err
+	}
+	m[pending.Event.Jti] = pending.Entry
+	if err := SaveDslMap(f.MapPath, m); err != nil {
+		return err
+	}
+	return os.Remove(f.PendingPath)
+}
+
+// Whether the log ends with the event
+func isLastEvent(events []StatusEvent, ev StatusEvent) (bool, error) {
+	if len(events) == 0 {
+		return false, nil
+	}
+	last, err := json.Marshal(events[len(events)-1])
+	if err != nil {
+		return false, err
+	}
+	data, err := json.Marshal(ev)
+	if err != nil {
+		return false, err
+	}
+	return bytes.Equal(last, data), nil
+}
+
+// The issuer keys written before key rotation (a single JWK) are migrated to a key ring
+func (f *FileStore) LoadIssuerKeys() (*KeyRing, error) {
+	data, err := os.ReadFile(f.KeyPath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load the issuer key: %w", err)
+	}
+	data, sealed, err := openSecret(data)
+	if err != nil {
+		return nil, err
+	}
+	ring, migrated, err := decodeKeyRing(data)
+	if err != nil {
+		return nil, err
+	}
+	if migrated {
+		fmt.Printf("> Migrating %s to a key ring\n", f.KeyPath)
+	}
+	if !sealed && keystoreSealed() {
+		fmt.Printf("> Encrypting %s with the keystore passphrase\n", f.KeyPath)
+		migrated = true
+	}
+	if migrated {
+		if err := f.SaveIssuerKeys(ring); err != nil {
+			return nil, err
+		}
+	}
+	return ring, nil
+}
+
+func (f *FileStore) SaveIssuerKeys(ring *KeyRing) error {
+	return SaveSecretJSON(ring, f.KeyPath)
+}
+
+func (f *FileStore) LoadSeedMaster() ([]byte, error) {
+	var record seedMasterRecord
+	migrate, err := LoadSecretJSON(&record, f.SeedPath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load the seed master secret: %w", err)
+	}
+	if len(record.SeedMaster) < seedMasterSize {
+		return nil, fmt.Errorf("invalid seed master secret in %s", f.SeedPath)
+	}
+	if migrate {
+		fmt.Printf("> Encrypting %s with the keystore passphrase\n", f.SeedPath)
+		if err := f.SaveSeedMaster(record.SeedMaster); err != nil {
+			return nil, err
+		}
+	}
+	return record.SeedMaster, nil
+}
+
+func (f *FileStore) SaveSeedMaster(master []byte) error {
+	return SaveSecretJSON(seedMasterRecord{SeedMaster: master}, f.SeedPath)
+}
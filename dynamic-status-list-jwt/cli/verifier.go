@@ -0,0 +1,195 @@
+package main
+
+import (
+	"crypto"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/lestrrat-go/jwx/v3/jwk"
+	"github.com/lestrrat-go/jwx/v3/jws"
+	"github.com/lestrrat-go/jwx/v3/jwt"
+)
+
+const defaultClockSkew = 30 * time.Second
+
+// Reasons a status list is rejected
+var (
+	ErrDslMalformed    = errors.New("status list is malformed")
+	ErrDslUntrustedKey = errors.New("status list is not signed by a trusted issuer key")
+	ErrDslSignature    = errors.New("status list signature is invalid")
+	ErrDslNotYetValid  = errors.New("status list is not yet valid")
+	ErrDslExpired      = errors.New("status list has expired")
+	ErrDslSuperseded   = errors.New("status list has been superseded by a newer list")
+)
+
+// VerifyOptions configure how a status list is trusted and validated
+// If neither IssuerJWK nor IssuerJWKS is set, the key embedded in the jwk header is used
+// and its thumbprint must match the iss claim (and Issuer, if set)
+type VerifyOptions struct {
+	IssuerJWK  string        // path to the trusted issuer JWK
+	IssuerJWKS string        // path or URL of a JWKS with the trusted issuer keys
+	Issuer     string        // expected iss claim (issuer id)
+	Algorithms []string      // accepted signature algorithms, all supported algorithms if empty
+	ClockSkew  time.Duration // tolerated clock skew for nbf, exp and nxt
+	MaxStale   time.Duration // accept expired or superseded lists for this long (offline verification)
+
+	// Holder proofs
+	Credential         string        // path to the credential, its cnf key verifies signed proofs
+	Audience           string        // expected aud of the proof (this verifier)
+	Nonce              string        // expected nonce of the proof
+	MaxProofAge        time.Duration // oldest accepted proof, zero means 5 minutes
+	AllowUnsignedProof bool          // accept plain JSON proofs, they can be replayed
+	AllowUnboundProof  bool          // accept signed proofs without checking aud and nonce, they can be replayed until they expire
+	Now                time.Time     // verification time, zero means now
+}
+
+// Accepted signature algorithms
+func (o VerifyOptions) allowedAlgorithms() []string {
+	if len(o.Algorithms) == 0 {
+		return supportedAlgorithms
+	}
+	return o.Algorithms
+}
+
+// Load the trusted issuer keys
+func (o VerifyOptions) trustedKeys(raw []byte) (jwk.Set, error) {
+	switch {
+	case o.IssuerJWK != "":
+		key, err := LoadJWK(o.IssuerJWK)
+		if err != nil {
+			return nil, err
+		}
+		pk, err := key.PublicKey()
+		if err != nil {
+			return nil, err
+		}
+		set := jwk.NewSet()
+		if err := set.AddKey(pk); err != nil {
+			return nil, err
+		}
+		return set, nil
+	case o.IssuerJWKS != "":
+		set, err := loadJWKS(o.IssuerJWKS, jwtKeyID(raw))
+		if err != nil {
+			return nil, fmt.Errorf("failed to read JWKS: %w", err)
+		}
+		return jwk.PublicSetOf(set)
+	}
+
+	// Use the embedded key, bound to the list by the iss thumbprint
+	msg, err := jws.Parse(raw)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrDslMalformed, err)
+	}
+	if len(msg.Signatures()) != 1 {
+		return nil, fmt.Errorf("%w: expected a single signature", ErrDslMalformed)
+	}
+	key, ok := msg.Signatures()[0].ProtectedHeaders().JWK()
+	if !ok {
+		return nil, fmt.Errorf("%w: jwk header missing", ErrDslUntrustedKey)
+	}
+	thumbprint, err := key.Thumbprint(crypto.SHA256)
+	if err != nil {
+		return nil, err
+	}
+	payload, err := jwt.Parse(msg.Payload(), jwt.WithVerify(false), jwt.WithValidate(false))
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrDslMalformed, err)
+	}
+	var iss string
+	if err := payload.Get(jwt.IssuerKey, &iss); err != nil {
+		return nil, fmt.Errorf("%w: iss claim missing", ErrDslMalformed)
+	}
+	if iss != hex.EncodeToString(thumbprint) {
+		return nil, fmt.Errorf("%w: jwk header does not match iss, use the issuer JWKS for rotated keys", ErrDslUntrustedKey)
+	}
+	if o.Issuer != "" && iss != o.Issuer {
+		return nil, fmt.Errorf("%w: unexpected issuer %s", ErrDslUntrustedKey, iss)
+	}
+	set := jwk.NewSet()
+	if err := set.AddKey(key); err != nil {
+		return nil, err
+	}
+	return set, nil
+}
+
+// Key ID (kid header) of a signed JWT, empty if it has none
+func jwtKeyID(raw []byte) string {
+	msg, err := jws.Parse(raw)
+	if err != nil || len(msg.Signatures()) != 1 {
+		return ""
+	}
+	kid, _ := msg.Signatures()[0].ProtectedHeaders().KeyID()
+	return kid
+}
+
+// ParseDslJWT verifies the signature of a dSL JWT against the trusted issuer keys
+func ParseDslJWT(raw []byte, opts VerifyOptions) (jwt.Token, error) {
+	t, err := parseTrustedJWT(raw, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	var typ string
+	if err := t.Get("typ", &typ); err != nil || typ != "dsl/v1" {
+		return nil, fmt.Errorf("%w: unexpected typ %q", ErrDslMalformed, typ)
+	}
+	return t, nil
+}
+
+// Verify the signature of an issuer signed JWT (status list or credential) against the trusted issuer keys
+func parseTrustedJWT(raw []byte, opts VerifyOptions) (jwt.Token, error) {
+	set, err := opts.trustedKeys(raw)
+	if err != nil {
+		return nil, err
+	}
+
+	t, err := verifyJWT(raw, set, opts.allowedAlgorithms())
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrDslSignature, err)
+	}
+	return t, nil
+}
+
+// ValidateDslJWT enforces the nbf, exp and nxt claims of a dSL JWT
+func ValidateDslJWT(t jwt.Token, opts VerifyOptions) error {
+	now := opts.Now
+	if now.IsZero() {
+		now = time.Now()
+	}
+
+	nbf, nxt, err := dslWindow(t)
+	if err != nil {
+		return err
+	}
+	var exp time.Time
+	if err := t.Get(jwt.ExpirationKey, &exp); err != nil {
+		return fmt.Errorf("%w: exp claim missing", ErrDslMalformed)
+	}
+
+	if now.Add(opts.ClockSkew).Unix() < nbf {
+		return fmt.Errorf("%w: nbf is %d", ErrDslNotYetValid, nbf)
+	}
+	if now.Add(-opts.ClockSkew-opts.MaxStale).Unix() > exp.Unix() {
+		return fmt.Errorf("%w: exp was %d", ErrDslExpired, exp.Unix())
+	}
+	if now.Add(-opts.ClockSkew-opts.MaxStale).Unix() >= nxt {
+		return fmt.Errorf("%w: nxt was %d", ErrDslSuperseded, nxt)
+	}
+	return nil
+}
+
+// Window of a dSL JWT: nbf <= t < nxt
+func dslWindow(t jwt.Token) (int64, int64, error) {
+	var nbf time.Time
+	if err := t.Get(jwt.NotBeforeKey, &nbf); err != nil {
+		return 0, 0, fmt.Errorf("%w: nbf claim missing", ErrDslMalformed)
+	}
+	var nxt float64
+	if err := t.Get("nxt", &nxt); err != nil {
+		return 0, 0, fmt.Errorf("%w: nxt claim missing", ErrDslMalformed)
+	}
+	return nbf.Unix(), int64(nxt), nil
+}